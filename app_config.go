@@ -0,0 +1,270 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// appSpecOverrides holds the command line values that can override an app spec.
+type appSpecOverrides struct {
+	configPath     string
+	name           string
+	image          string
+	migrationImage string
+	containerPort  int
+	replicas       int
+	migrationTTL   int
+	service        serviceOverrides
+}
+
+// register adds the app spec flags shared by the migrate, deploy, service and
+// diff commands to fs.
+func (o *appSpecOverrides) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.configPath, "config", "", "App spec file (YAML or JSON) describing the Deployment, migration Job and Service")
+	fs.StringVar(&o.name, "deployment-name", "go-infra", "deployment name")
+	fs.StringVar(&o.image, "image-name", "ghcr.io/babbage88/go-infra:v1.2.2", "Image name to use for deployment")
+	fs.StringVar(&o.migrationImage, "dbinit-image-name", "ghcr.io/babbage88/init-infradb:v1.2.2", "Image name to use for DB Migration init")
+	fs.IntVar(&o.containerPort, "container-port", 8993, "Container port")
+	fs.IntVar(&o.replicas, "replicas", 3, "Number of replicas in deployment")
+	fs.IntVar(&o.migrationTTL, "migration-ttl", 0, "Seconds before Kubernetes deletes finished migration jobs, 0 keeps them until cleanup prunes them. Finished jobs record which migrations ran, so a short TTL disables skipping them")
+
+	svc := &o.service
+	fs.StringVar(&svc.name, "service-name", "go-infra-svc", "Service Name")
+	fs.StringVar(&svc.serviceType, "service-type", string(corev1.ServiceTypeLoadBalancer), "Service type: ClusterIP, NodePort or LoadBalancer")
+	fs.BoolVar(&svc.headless, "headless", false, "Create a headless ClusterIP service (clusterIP: None)")
+	fs.Var(&svc.ports, "service-port", "Service port as name:port[:targetPort[:nodePort]][/protocol], repeatable. Defaults to the container ports")
+	fs.StringVar(&svc.externalTrafficPolicy, "external-traffic-policy", "", "externalTrafficPolicy for NodePort and LoadBalancer services: Cluster or Local")
+	fs.StringVar(&svc.loadBalancerIP, "load-balancer-ip", "", "Requested LoadBalancer IP")
+	fs.StringVar(&svc.loadBalancerClass, "load-balancer-class", "", "LoadBalancer class, e.g. for kube-vip")
+	fs.BoolVar(&svc.allocateNodePorts, "allocate-nodeport", false, "Allocate NodePort for LoadBalancer deployment")
+	fs.Var(&svc.annotations, "service-annotation", "Service annotation as key=value, repeatable (e.g. MetalLB address pool)")
+}
+
+// serviceOverrides holds the command line values describing the Service.
+type serviceOverrides struct {
+	name                  string
+	serviceType           string
+	headless              bool
+	ports                 servicePortsFlag
+	externalTrafficPolicy string
+	loadBalancerIP        string
+	loadBalancerClass     string
+	allocateNodePorts     bool
+	annotations           keyValueFlag
+}
+
+// servicePortsFlag collects repeated -service-port values of the form
+// name:port[:targetPort[:nodePort]][/protocol], e.g. metrics:9090 or grpc:443:8443/TCP.
+type servicePortsFlag []corev1.ServicePort
+
+func (f *servicePortsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	for _, p := range *f {
+		parts = append(parts, fmt.Sprintf("%s:%d:%s:%d/%s", p.Name, p.Port, p.TargetPort.String(), p.NodePort, p.Protocol))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *servicePortsFlag) Set(value string) error {
+	port, err := parseServicePort(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, port)
+	return nil
+}
+
+func parseServicePort(value string) (corev1.ServicePort, error) {
+	port := corev1.ServicePort{Protocol: corev1.ProtocolTCP}
+	spec, protocol, hasProtocol := strings.Cut(value, "/")
+	if hasProtocol {
+		port.Protocol = corev1.Protocol(strings.ToUpper(protocol))
+	}
+
+	fields := strings.Split(spec, ":")
+	if len(fields) < 2 || len(fields) > 4 || fields[0] == "" {
+		return port, fmt.Errorf("invalid service port %q, expected name:port[:targetPort[:nodePort]][/protocol]", value)
+	}
+	port.Name = fields[0]
+	number, err := strconv.ParseInt(fields[1], 10, 32)
+	if err != nil {
+		return port, fmt.Errorf("invalid port in %q: %w", value, err)
+	}
+	port.Port = int32(number)
+	port.TargetPort = intstr.FromInt32(port.Port)
+	if len(fields) > 2 && fields[2] != "" {
+		port.TargetPort = intstr.Parse(fields[2])
+	}
+	if len(fields) > 3 {
+		nodePort, err := strconv.ParseInt(fields[3], 10, 32)
+		if err != nil {
+			return port, fmt.Errorf("invalid nodePort in %q: %w", value, err)
+		}
+		port.NodePort = int32(nodePort)
+	}
+	return port, nil
+}
+
+// keyValueFlag collects repeated key=value flags.
+type keyValueFlag map[string]string
+
+func (f *keyValueFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	for k, v := range *f {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *keyValueFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	if *f == nil {
+		*f = make(keyValueFlag)
+	}
+	(*f)[key] = val
+	return nil
+}
+
+// appSpecFlagsSet reports whether any of the flags added by register was set
+// on fs.
+func appSpecFlagsSet(fs *flag.FlagSet) bool {
+	names := flag.NewFlagSet("", flag.ContinueOnError)
+	(&appSpecOverrides{}).register(names)
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if names.Lookup(f.Name) != nil {
+			set = true
+		}
+	})
+	return set
+}
+
+func flagWasSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadAppSpec reads the app spec from configPath, or builds the default go-infra
+// spec from the flags when no config file is given. Flags explicitly set on the
+// command line take precedence over values from the file.
+func loadAppSpec(fs *flag.FlagSet, o appSpecOverrides) (*appspec.AppSpec, error) {
+	containerPort, replicas, migrationTTL := int32(o.containerPort), int32(o.replicas), int32(o.migrationTTL)
+	if o.configPath == "" {
+		spec := appspec.Default(o.name, o.image, o.migrationImage, containerPort, replicas)
+		spec.Migration.TTLSecondsAfterFinished = ttlSeconds(migrationTTL)
+		applyServiceOverrides(spec, o.service, func(string) bool { return true })
+		return spec, spec.Validate()
+	}
+
+	spec, err := appspec.Load(o.configPath)
+	if err != nil {
+		return nil, err
+	}
+	flagWasSet := func(name string) bool { return flagWasSet(fs, name) }
+
+	if flagWasSet("deployment-name") {
+		spec.Name = o.name
+	}
+	if flagWasSet("image-name") {
+		spec.Containers[0].Image = o.image
+	}
+	if flagWasSet("replicas") {
+		spec.Replicas = &replicas
+	}
+	if flagWasSet("container-port") {
+		if len(spec.Containers[0].Ports) == 0 {
+			spec.Containers[0].Ports = append(spec.Containers[0].Ports, corev1.ContainerPort{})
+		}
+		spec.Containers[0].Ports[0].ContainerPort = containerPort
+	}
+	if spec.Migration != nil {
+		if flagWasSet("dbinit-image-name") {
+			spec.Migration.Container.Image = o.migrationImage
+		}
+		if flagWasSet("migration-ttl") {
+			spec.Migration.TTLSecondsAfterFinished = ttlSeconds(migrationTTL)
+		}
+	}
+	applyServiceOverrides(spec, o.service, flagWasSet)
+	return spec, spec.Validate()
+}
+
+// ttlSeconds returns the TTL of finished migration jobs, or nil for 0 so they
+// are kept.
+func ttlSeconds(ttl int32) *int32 {
+	if ttl <= 0 {
+		return nil
+	}
+	return &ttl
+}
+
+// applyServiceOverrides copies the service flags accepted by isSet onto the spec,
+// adding a Service to the spec if it has none.
+func applyServiceOverrides(spec *appspec.AppSpec, o serviceOverrides, isSet func(string) bool) {
+	names := []string{"service-name", "service-type", "headless", "service-port", "external-traffic-policy",
+		"load-balancer-ip", "load-balancer-class", "allocate-nodeport", "service-annotation"}
+	anySet := false
+	for _, name := range names {
+		anySet = anySet || isSet(name)
+	}
+	if !anySet {
+		return
+	}
+	if spec.Service == nil {
+		spec.Service = &appspec.ServiceSpec{}
+	}
+
+	svc := spec.Service
+	if isSet("service-name") {
+		svc.Name = o.name
+	}
+	if isSet("service-type") {
+		svc.Type = corev1.ServiceType(o.serviceType)
+	}
+	if isSet("headless") {
+		svc.Headless = o.headless
+	}
+	if isSet("service-port") && len(o.ports) > 0 {
+		svc.Ports = o.ports
+	}
+	if isSet("external-traffic-policy") && o.externalTrafficPolicy != "" {
+		svc.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyType(o.externalTrafficPolicy)
+	}
+	if isSet("load-balancer-ip") && o.loadBalancerIP != "" {
+		svc.LoadBalancerIP = o.loadBalancerIP
+	}
+	if isSet("load-balancer-class") && o.loadBalancerClass != "" {
+		svc.LoadBalancerClass = &o.loadBalancerClass
+	}
+	if isSet("allocate-nodeport") && svc.Type == corev1.ServiceTypeLoadBalancer {
+		svc.AllocateLoadBalancerNodePorts = &o.allocateNodePorts
+	}
+	if isSet("service-annotation") && len(o.annotations) > 0 {
+		if svc.Annotations == nil {
+			svc.Annotations = make(map[string]string)
+		}
+		for k, v := range o.annotations {
+			svc.Annotations[k] = v
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestLoadAppSpecOverrides(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		wantSpecSet  bool
+		wantImage    string
+		wantReplicas int32
+	}{
+		{
+			name:         "manifests only",
+			args:         []string{"-f", "manifests/"},
+			wantImage:    "ghcr.io/babbage88/go-infra:v1.2.2",
+			wantReplicas: 3,
+		},
+		{
+			name:         "file with image override",
+			args:         []string{"-config", "config/go-infra.yaml", "-image-name", "ghcr.io/babbage88/go-infra:v1.3.0"},
+			wantSpecSet:  true,
+			wantImage:    "ghcr.io/babbage88/go-infra:v1.3.0",
+			wantReplicas: 3,
+		},
+		{
+			name:         "flags only",
+			args:         []string{"-replicas", "2"},
+			wantSpecSet:  true,
+			wantImage:    "ghcr.io/babbage88/go-infra:v1.2.2",
+			wantReplicas: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			var files stringsFlag
+			fs.Var(&files, "f", "")
+			var app appSpecOverrides
+			app.register(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := appSpecFlagsSet(fs); got != tt.wantSpecSet {
+				t.Errorf("appSpecFlagsSet() = %t, want %t", got, tt.wantSpecSet)
+			}
+			spec, err := loadAppSpec(fs, app)
+			if err != nil {
+				t.Fatalf("loadAppSpec() error = %v", err)
+			}
+			if got := spec.Containers[0].Image; got != tt.wantImage {
+				t.Errorf("image = %q, want %q", got, tt.wantImage)
+			}
+			if got := *spec.Replicas; got != tt.wantReplicas {
+				t.Errorf("replicas = %d, want %d", got, tt.wantReplicas)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+)
+
+// ErrInterrupted is the cause of the root context when kubeinit receives SIGINT or SIGTERM.
+var ErrInterrupted = errors.New("interrupted")
+
+// globalOptions are the flags accepted before the command name and by every command.
+type globalOptions struct {
+	kubeconfig     string
+	context        string
+	cluster        string
+	authMode       string
+	namespace      string
+	output         string
+	logLevel       string
+	timeout        time.Duration
+	requestTimeout time.Duration
+	retries        int
+
+	// format is the parsed -output flag, results are written to stdout in it.
+	format OutputFormat
+	stdout io.Writer
+	// level is the parsed -log-level flag.
+	level slog.Level
+
+	// ctx is the root context of the command, set up by runCLI and bounded by
+	// -timeout once the command's flags are parsed.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// register adds the global flags to fs, using the current values as defaults so
+// flags given before the command name carry over to the command's flag set.
+func (g *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.kubeconfig, "kubeconfig", g.kubeconfig, "kubeconfig file to use, defaults to the files in $KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&g.context, "context", g.context, "kubeconfig context to use, defaults to the current context")
+	fs.StringVar(&g.cluster, "cluster", g.cluster, "kubeconfig cluster to use, defaults to the cluster of the context")
+	fs.StringVar(&g.authMode, "auth-mode", g.authMode, "Credentials to use: auto (in-cluster service account when running in a pod, else kubeconfig), incluster or kubeconfig")
+	fs.StringVar(&g.namespace, "namespace", g.namespace, "Namespace for the app and objects that do not set one")
+	fs.StringVar(&g.output, "output", g.output, "Output format: table, json or yaml. json and yaml print only the result on stdout")
+	fs.StringVar(&g.logLevel, "log-level", g.logLevel, "Least severe messages to print: debug, info, warn or error")
+	fs.DurationVar(&g.timeout, "timeout", g.timeout, "Overall time limit for the command, 0 for none")
+	fs.DurationVar(&g.requestTimeout, "request-timeout", g.requestTimeout, "Time limit for a single API request, 0 for none")
+	fs.IntVar(&g.retries, "retries", g.retries, "Attempts for changes failing with conflicts, timeouts, 429 or 5xx responses, 1 disables retries")
+}
+
+func (g *globalOptions) validate() error {
+	if _, err := ParseAuthMode(g.authMode); err != nil {
+		return err
+	}
+	format, err := ParseOutputFormat(g.output)
+	if err != nil {
+		return err
+	}
+	g.format = format
+	if err := g.level.UnmarshalText([]byte(g.logLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q, expected debug, info, warn or error", g.logLevel)
+	}
+	return nil
+}
+
+// kubeClient creates a KubeClient for the selected auth mode, kubeconfig, context
+// and cluster and prints the target so it is visible before anything is changed.
+func (g *globalOptions) kubeClient(opts ...KubeClientOption) (*KubeClient, error) {
+	authMode, err := ParseAuthMode(g.authMode)
+	if err != nil {
+		return nil, err
+	}
+	opts = append([]KubeClientOption{
+		WithKubeconfigPath(g.kubeconfig),
+		WithKubeContext(g.context),
+		WithKubeCluster(g.cluster),
+		WithAuthMode(authMode),
+		WithContext(g.ctx),
+		WithOperationTimeout(g.requestTimeout),
+		WithRetryAttempts(g.retries),
+	}, opts...)
+	kubeClient := NewKubeClient(opts...)
+	if err := kubeClient.Initialize(); err != nil {
+		return nil, fmt.Errorf("error initializing kube client %w", err)
+	}
+	pretty.Printf("Targeting %s", kubeClient.Target)
+	return kubeClient, nil
+}
+
+// applyTimeout bounds the root context by the -timeout flag.
+func (g *globalOptions) applyTimeout() {
+	if g.timeout <= 0 {
+		return
+	}
+	g.ctx, g.cancel = context.WithTimeoutCause(g.ctx, g.timeout, fmt.Errorf("overall timeout of %s exceeded", g.timeout))
+}
+
+// rootContext returns a context cancelled on the first SIGINT or SIGTERM with
+// ErrInterrupted as its cause. A second signal terminates the process as usual.
+func rootContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			pretty.PrintWarningf("Received %s, cancelling in-flight requests", sig)
+			cancel(fmt.Errorf("%w by %s", ErrInterrupted, sig))
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
+
+// namespaceFlags controls how the target namespace is prepared by the commands
+// that deploy the app.
+type namespaceFlags struct {
+	create          bool
+	labels          keyValueFlag
+	skipSecretCheck bool
+}
+
+func (n *namespaceFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&n.create, "create-namespace", false, "Create the namespace if it does not exist")
+	fs.Var(&n.labels, "namespace-label", "Label as key=value set on the namespace with -create-namespace, repeatable")
+	fs.BoolVar(&n.skipSecretCheck, "skip-secret-check", false, "Do not check that the secrets used by the app spec exist")
+}
+
+// prepare creates the namespace when requested and checks that secrets exist in
+// it. Missing secrets are only a warning in dry-run mode, where the namespace may
+// not have been created.
+func (n *namespaceFlags) prepare(kubeClient *KubeClient, namespace string, secrets []string) error {
+	if n.create {
+		if err := kubeClient.EnsureNamespace(namespace, n.labels); err != nil {
+			return err
+		}
+	}
+	if n.skipSecretCheck || len(secrets) == 0 {
+		return nil
+	}
+	err := kubeClient.VerifySecrets(namespace, secrets)
+	if err != nil && kubeClient.dryRunning() {
+		pretty.PrintWarningf("%s", err.Error())
+		return nil
+	}
+	return err
+}
+
+// command is a kubeinit subcommand. run returns the process exit code.
+type command struct {
+	name    string
+	summary string
+	run     func(g *globalOptions, args []string) int
+}
+
+var commands = []command{
+	{"preflight", "Check the cluster, RBAC, secrets and quotas before a deploy", runPreflight},
+	{"migrate", "Run the database migration Job if the migration image changed", runMigrate},
+	{"deploy", "Migrate, then apply the Deployment and its Service", runDeploy},
+	{"service", "Apply the app's Service and wait for its address", runService},
+	{"status", "Show the Deployment rollout, Service address and latest migration", runStatus},
+	{"bump", "Print the next release version", runBump},
+	{"apply", "Server-side apply manifest files and directories", runApply},
+	{"diff", "Show what applying the app spec or manifests would change", runDiff},
+	{"cleanup", "Delete finished migration Jobs", runCleanup},
+	{"jobs", "Show the database migration Job history, optionally live", runJobs},
+}
+
+// newCommandFlagSet returns the flag set of a command with the global flags
+// registered and a usage message built from usage and description.
+func newCommandFlagSet(g *globalOptions, name string, usage string, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	g.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n", filepath.Base(os.Args[0]), name, usage)
+		fmt.Fprintf(fs.Output(), "%s\n\nFlags:\n", description)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseCommandFlags parses args into fs, validates the global flags and applies
+// -output and -timeout.
+func parseCommandFlags(g *globalOptions, fs *flag.FlagSet, args []string) bool {
+	fs.Parse(args)
+	if err := g.validate(); err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return false
+	}
+	g.applyOutput()
+	g.applyTimeout()
+	return true
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s [global flags] <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+		for _, cmd := range commands {
+			fmt.Fprintf(out, "  %-11s %s\n", cmd.name, cmd.summary)
+		}
+		fmt.Fprintf(out, "\nGlobal flags, also accepted after the command name:\n")
+		fs.PrintDefaults()
+		fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n", filepath.Base(os.Args[0]))
+	}
+}
+
+// runCLI dispatches args to the selected command and returns the exit code.
+func runCLI(args []string) int {
+	ctx, stop := rootContext()
+	defer stop()
+	g := &globalOptions{
+		authMode:       string(AuthAuto),
+		namespace:      "default",
+		output:         string(OutputTable),
+		format:         OutputTable,
+		logLevel:       "info",
+		stdout:         os.Stdout,
+		requestTimeout: defaultOperationTimeout,
+		retries:        defaultRetryAttempts,
+		ctx:            ctx,
+	}
+	fs := flag.NewFlagSet(filepath.Base(os.Args[0]), flag.ExitOnError)
+	g.register(fs)
+	fs.Usage = usage(fs)
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	name := fs.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			exitCode := cmd.run(g, fs.Args()[1:])
+			if g.cancel != nil {
+				g.cancel()
+			}
+			if errors.Is(context.Cause(ctx), ErrInterrupted) {
+				return exitInterrupted
+			}
+			return exitCode
+		}
+	}
+	pretty.PrintErrorf("Unknown command %q", name)
+	fs.Usage()
+	return exitUsage
+}
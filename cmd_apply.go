@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// stringsFlag collects repeated string flags.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// objectResult is the outcome of applying or deleting one object.
+type objectResult struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Result    string `json:"result"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (r objectResult) row() []string {
+	return []string{strings.ToLower(r.Kind) + "/" + r.Name, orNone(r.Namespace), r.Result, r.Error}
+}
+
+// objectsResult is the result of `kubeinit apply` and `kubeinit cleanup`.
+type objectsResult struct {
+	DryRun  DryRunMode     `json:"dryRun"`
+	Objects []objectResult `json:"objects"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func (r *objectsResult) printTable() {
+	if len(r.Objects) == 0 {
+		return
+	}
+	rows := make([][]string, 0, len(r.Objects))
+	for _, obj := range r.Objects {
+		rows = append(rows, obj.row())
+	}
+	lines := formatTable([]string{"OBJECT", "NAMESPACE", "RESULT", "ERROR"}, rows)
+	pretty.Printf("%s", lines[0])
+	for i, line := range lines[1:] {
+		if r.Objects[i].Error != "" {
+			pretty.PrintErrorf("%s", line)
+		} else {
+			pretty.Printf("%s", line)
+		}
+	}
+}
+
+// runApply implements `kubeinit apply -f <file|dir> ...`.
+func runApply(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "apply", "-f <file|dir> [-f ...] [flags]",
+		"Applies YAML and JSON manifests with server-side apply, ordering namespaces,\n"+
+			"service accounts, RBAC, secrets and config before workloads. With -server-side=false\n"+
+			"objects are created, or replaced at their live resourceVersion.")
+	var files stringsFlag
+	fs.Var(&files, "f", "Manifest file or directory to apply, repeatable")
+	forceConflicts := fs.Bool("force-conflicts", false, "Take ownership of fields changed by other managers")
+	serverSide := fs.Bool("server-side", true, "Use server-side apply; false creates objects or replaces them at their live resourceVersion")
+	dryRun := registerDryRunFlag(fs)
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+	if *forceConflicts && !*serverSide {
+		pretty.PrintErrorf("-force-conflicts requires -server-side")
+		return exitUsage
+	}
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	objects, err := LoadManifests(files)
+	if err != nil {
+		pretty.PrintErrorf("Error loading manifests: %s", err.Error())
+		return exitFailure
+	}
+
+	dryRunMode, err := ParseDryRunMode(*dryRun)
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitUsage
+	}
+	kubeClient, err := g.kubeClient(WithDryRun(dryRunMode))
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+
+	result := &objectsResult{DryRun: dryRunMode, Objects: []objectResult{}}
+	defer g.printResult(result)
+	failed := 0
+	for _, obj := range objects {
+		var applied *unstructured.Unstructured
+		if *serverSide {
+			applied, err = kubeClient.ApplyObject(obj, g.namespace, ApplyOptions{Force: *forceConflicts})
+		} else {
+			applied, err = kubeClient.CreateOrUpdateObject(obj, g.namespace)
+		}
+		if err != nil {
+			pretty.PrintErrorf("%s", err.Error())
+			result.Objects = append(result.Objects, objectResult{
+				Kind: obj.GetKind(), Name: obj.GetName(), Namespace: obj.GetNamespace(), Result: "failed", Error: err.Error(),
+			})
+			failed++
+			continue
+		}
+		result.Objects = append(result.Objects, objectResult{
+			Kind: applied.GetKind(), Name: applied.GetName(), Namespace: applied.GetNamespace(), Result: "applied",
+		})
+	}
+	if failed > 0 {
+		pretty.PrintErrorf("%d of %d objects failed to apply", failed, len(objects))
+		return exitFailure
+	}
+	return 0
+}
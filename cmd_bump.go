@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/babbage88/infra-kubeinit/internal/bumper"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+)
+
+// bumpResult is the result of `kubeinit bump`.
+type bumpResult struct {
+	Previous string `json:"previous"`
+	Version  string `json:"version"`
+}
+
+// printTable prints only the version, uncolored, so scripts can capture it.
+func (r *bumpResult) printTable() {
+	fmt.Fprintln(pretty.Default().Writer(), r.Version)
+}
+
+// runBump implements `kubeinit bump`, printing only the new version so it can be
+// captured by scripts.
+func runBump(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "bump", "-latest-version v1.2.2 [-increment-type patch] [-preid rc] [-build metadata]",
+		"Prints the release version following -latest-version, a semantic version such as\n"+
+			"v1.2.2 or v1.3.0-rc.1. prerelease bumps v1.3.0-rc.1 to v1.3.0-rc.2 and v1.2.2 to\n"+
+			"v1.2.3-rc.1; release bumps v1.3.0-rc.2 to v1.3.0.")
+	currentVersion := fs.String("latest-version", "", "Version number to increment eg: v1.2.2")
+	bumpType := fs.String("increment-type", string(bumper.Patch), "major, minor, patch, prerelease or release")
+	preid := fs.String("preid", bumper.DefaultPreid, "Identifier of new pre-releases, e.g. alpha, beta or rc")
+	build := fs.String("build", "", "Build metadata appended to the new version, e.g. sha.5114f85")
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+	if *currentVersion == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	increment, err := bumper.ParseIncrement(*bumpType)
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitUsage
+	}
+
+	version, err := bumper.Parse(*currentVersion)
+	if err == nil {
+		version, err = version.Bump(increment, *preid)
+	}
+	if err == nil {
+		version, err = version.WithBuild(*build)
+	}
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	g.printResult(&bumpResult{Previous: *currentVersion, Version: version.String()})
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	batchv1 "k8s.io/api/batch/v1"
+)
+
+// finishedMigrationJobs returns the finished jobs that cleanup may delete, newest
+// first, keeping the keep most recent successful ones of each app so image-aware
+// migration skipping still has history to compare against.
+func finishedMigrationJobs(jobs []batchv1.Job, keep int) []batchv1.Job {
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[j].CreationTimestamp.Before(&jobs[i].CreationTimestamp)
+	})
+
+	var deletable []batchv1.Job
+	kept := make(map[string]int)
+	for _, job := range jobs {
+		finished, err := jobFinished(&job)
+		if !finished {
+			continue
+		}
+		if app := job.Labels["app"]; err == nil && kept[app] < keep {
+			kept[app]++
+			continue
+		}
+		deletable = append(deletable, job)
+	}
+	return deletable
+}
+
+// runCleanup implements `kubeinit cleanup`.
+func runCleanup(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "cleanup", "[flags]",
+		"Deletes finished database migration Jobs and their pods. Running Jobs and the\n"+
+			"-keep most recent successful ones of each app are left in place.")
+	app := fs.String("app", "", "Only delete migration jobs of this app")
+	keep := fs.Int("keep", 1, "Number of successful migration jobs to keep per app")
+	dryRun := registerDryRunFlag(fs)
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+	if *keep < 0 {
+		pretty.PrintErrorf("-keep must not be negative")
+		return exitUsage
+	}
+
+	mode, err := ParseDryRunMode(*dryRun)
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitUsage
+	}
+	kubeClient, err := g.kubeClient(WithDryRun(mode))
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+
+	selector := MigrationHistorySelector(*app, "")
+	result := &objectsResult{DryRun: mode, Objects: []objectResult{}}
+	defer g.printResult(result)
+	jobs, err := kubeClient.GetBatchJobByLabel(g.namespace, selector)
+	if err != nil {
+		pretty.PrintErrorf("Error listing migration jobs: %s", err.Error())
+		result.Error = err.Error()
+		return exitFailure
+	}
+	deletable := finishedMigrationJobs(jobs.Items, *keep)
+	if len(deletable) == 0 {
+		pretty.Print("No finished migration jobs to delete")
+		return 0
+	}
+	failed := 0
+	for _, job := range deletable {
+		deleted := objectResult{Kind: "Job", Name: job.Name, Namespace: job.Namespace, Result: "deleted"}
+		if err := kubeClient.DeleteJob(job.Namespace, job.Name); err != nil {
+			pretty.PrintErrorf("%s", err.Error())
+			deleted.Result, deleted.Error = "failed", err.Error()
+			failed++
+		}
+		result.Objects = append(result.Objects, deleted)
+	}
+	if failed > 0 {
+		pretty.PrintErrorf("%d of %d jobs failed to delete", failed, len(deletable))
+		return exitFailure
+	}
+	return 0
+}
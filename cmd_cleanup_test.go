@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFinishedMigrationJobs(t *testing.T) {
+	now := time.Now()
+	job := func(name string, app string, conditionType batchv1.JobConditionType, age time.Duration) batchv1.Job {
+		j := newMigrationJob(name, "ghcr.io/babbage88/"+app+"-migrate:v1", conditionType, now.Add(-age))
+		j.Labels, j.Annotations = migrationJobMetadata(app, "ghcr.io/babbage88/"+app+"-migrate:v1", "")
+		j.CreationTimestamp = metav1.Time{Time: now.Add(-age)}
+		return j
+	}
+	running := job("go-infra-running", "go-infra", batchv1.JobComplete, 0)
+	running.Status = batchv1.JobStatus{}
+
+	jobs := []batchv1.Job{
+		job("go-infra-old", "go-infra", batchv1.JobComplete, 3*time.Hour),
+		// billing's only success must survive the newer go-infra jobs.
+		job("billing-ok", "billing", batchv1.JobComplete, 2*time.Hour),
+		job("go-infra-failed", "go-infra", batchv1.JobFailed, 90*time.Minute),
+		job("go-infra-new", "go-infra", batchv1.JobComplete, time.Hour),
+		running,
+	}
+
+	var got []string
+	for _, j := range finishedMigrationJobs(jobs, 1) {
+		got = append(got, j.Name)
+	}
+	if want := []string{"go-infra-failed", "go-infra-old"}; !slices.Equal(got, want) {
+		t.Errorf("finishedMigrationJobs() = %q, want %q", got, want)
+	}
+}
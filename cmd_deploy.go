@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// serviceFlags holds the flags controlling how the Service is applied, shared by
+// the deploy and service commands.
+type serviceFlags struct {
+	loadBalancerTimeout time.Duration
+}
+
+func (s *serviceFlags) register(fs *flag.FlagSet) {
+	fs.DurationVar(&s.loadBalancerTimeout, "lb-timeout", 2*time.Minute, "How long to wait for the LoadBalancer external address")
+}
+
+// deployResult is the result of the migrate, deploy and service commands. Steps
+// that did not run are left out; Error is the error that stopped the command.
+type deployResult struct {
+	App        string            `json:"app"`
+	Namespace  string            `json:"namespace"`
+	DryRun     DryRunMode        `json:"dryRun"`
+	Preflight  []PreflightCheck  `json:"preflight,omitempty"`
+	Migration  *MigrationResult  `json:"migration,omitempty"`
+	Deployment *deploymentResult `json:"deployment,omitempty"`
+	Service    *serviceResult    `json:"service,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func newDeployResult(kubeClient *KubeClient, spec *appspec.AppSpec, namespace string) *deployResult {
+	return &deployResult{App: spec.Name, Namespace: namespace, DryRun: kubeClient.DryRun}
+}
+
+// fail prints err after prefix, records it as the reason the command stopped
+// and returns exitFailure.
+func (r *deployResult) fail(prefix string, err error) int {
+	pretty.PrintErrorf("%s%s", prefix, err.Error())
+	r.Error = err.Error()
+	return exitFailure
+}
+
+// printTable prints the migration and resource summaries. The preflight table
+// was already printed before anything was changed.
+func (r *deployResult) printTable() {
+	if r.Migration != nil {
+		r.Migration.printTable()
+	}
+	printResources(r.Deployment, r.Service, nil)
+}
+
+// deployService creates or updates the app's Service and waits for a
+// LoadBalancer address. A missing address is only a warning. The result is nil
+// when the app spec has no Service.
+func deployService(kubeClient *KubeClient, spec *appspec.AppSpec, namespace string, opts serviceFlags) (*serviceResult, error) {
+	service := spec.RenderService(namespace)
+	if service == nil {
+		pretty.Printf("App spec %s has no service, skipping", spec.Name)
+		return nil, nil
+	}
+	if err := kubeClient.CreateOrUpdateService(service); err != nil {
+		return nil, err
+	}
+	pretty.Printf("%s Service %s applied", service.Spec.Type, service.Name)
+	result := &serviceResult{Name: service.Name, Type: string(service.Spec.Type), External: []string{}}
+
+	if service.Spec.Type == corev1.ServiceTypeLoadBalancer && !kubeClient.dryRunning() {
+		addresses, err := kubeClient.WaitForLoadBalancerIngress(namespace, service.Name, opts.loadBalancerTimeout)
+		if err != nil {
+			pretty.PrintWarningf("Service has no external address yet: %s", err.Error())
+		}
+		result.External = append(result.External, addresses...)
+	}
+	return result, nil
+}
+
+// runDeploy implements `kubeinit deploy`.
+func runDeploy(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "deploy", "[flags]",
+		"Runs the database migration when needed, applies the Deployment, waits for it to\n"+
+			"roll out and applies its Service. Exits 3 when a failed rollout was rolled back.")
+	var app appSpecOverrides
+	app.register(fs)
+	var migration migrationFlags
+	migration.register(fs)
+	var svc serviceFlags
+	svc.register(fs)
+	var ns namespaceFlags
+	ns.register(fs)
+	var preflight preflightFlags
+	preflight.register(fs)
+	dryRun := registerDryRunFlag(fs)
+	skipMigration := fs.Bool("skip-migration", false, "Do not run the database migration")
+	skipService := fs.Bool("skip-service", false, "Do not apply the Service")
+	forceConflicts := fs.Bool("force-conflicts", false, "Take ownership of fields changed by other managers when applying")
+	rolloutRestart := fs.Bool("rollout-restart", true, "Restart the deployment's pods even when the pod template is unchanged")
+	rolloutTimeout := fs.Duration("rollout-timeout", 5*time.Minute, "How long to wait for the deployment rollout to finish")
+	autoRollback := fs.Bool("auto-rollback", false, "Restore the previous pod template when the rollout fails")
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+
+	spec, kubeClient, err := g.appCommand(fs, app, *dryRun)
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	namespace := g.namespace
+	result := newDeployResult(kubeClient, spec, namespace)
+	defer g.printResult(result)
+	preflightOpts := PreflightOptions{
+		Namespace:       namespace,
+		CreateNamespace: ns.create,
+		Migrate:         !*skipMigration,
+		Deploy:          true,
+		Service:         !*skipService,
+		SkipSecrets:     ns.skipSecretCheck,
+	}
+	if !preflight.run(kubeClient, spec, preflightOpts, result) {
+		return exitFailure
+	}
+	if err := ns.prepare(kubeClient, namespace, preflight.uncheckedSecrets(spec)); err != nil {
+		return result.fail("", err)
+	}
+
+	if !*skipMigration {
+		result.Migration, err = kubeClient.PrepDeployment(migration.options(spec, namespace))
+		if err != nil {
+			return result.fail("Error running migration: ", err)
+		}
+	}
+
+	var previousTemplate *corev1.PodTemplateSpec
+	if *autoRollback {
+		previousTemplate, err = kubeClient.GetDeploymentTemplate(namespace, spec.Name)
+		if err != nil {
+			return result.fail("Error reading current deployment for rollback: ", err)
+		}
+	}
+
+	pretty.Print("Creating or Updating deployment...")
+	err = kubeClient.CreateOrUpdateDeployment(&namespace, spec, *rolloutRestart, ApplyOptions{Force: *forceConflicts})
+	if err != nil {
+		return result.fail("Error applying deployment: ", err)
+	}
+	pretty.Print("deployment applied")
+
+	if kubeClient.dryRunning() {
+		pretty.Print("Dry run: not waiting for deployment rollout")
+		result.Deployment = &deploymentResult{Name: spec.Name, Rollout: rolloutDryRun}
+	} else {
+		err = kubeClient.WaitForRollout(namespace, spec.Name, *rolloutTimeout)
+		result.Deployment = deploymentOutcome(kubeClient, namespace, spec.Name)
+	}
+	if err != nil {
+		result.Deployment.Rollout, result.Deployment.Message = rolloutFailed, err.Error()
+		if !*autoRollback {
+			return result.fail("Deployment rollout failed: ", err)
+		}
+		pretty.PrintErrorf("Deployment rollout failed: %s", err.Error())
+		rollbackErr := kubeClient.RollbackDeployment(namespace, spec, previousTemplate, err.Error(), *rolloutTimeout)
+		if rollbackErr != nil {
+			return result.fail("Rollback failed: ", rollbackErr)
+		}
+		result.Deployment = deploymentOutcome(kubeClient, namespace, spec.Name)
+		result.Deployment.Rollout, result.Deployment.Message = rolloutRolledBack, err.Error()
+		result.Error = err.Error()
+		return exitRolledBack
+	}
+
+	if *skipService {
+		return 0
+	}
+	result.Service, err = deployService(kubeClient, spec, namespace, svc)
+	if err != nil {
+		return result.fail("Error applying service: ", err)
+	}
+	return 0
+}
+
+// deploymentOutcome reads the Deployment back for the result. When that fails
+// only its name is reported.
+func deploymentOutcome(kubeClient *KubeClient, namespace string, name string) *deploymentResult {
+	deployment, err := kubeClient.GetDeployment(namespace, name)
+	if err != nil {
+		pretty.PrintWarningf("%s", err.Error())
+		return &deploymentResult{Name: name}
+	}
+	return newDeploymentResult(deployment)
+}
+
+// runService implements `kubeinit service`.
+func runService(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "service", "[flags]",
+		"Creates or updates the app's Service, keeping cluster-allocated IPs and node ports,\n"+
+			"and waits for a LoadBalancer address. No migration or deployment is run.")
+	var app appSpecOverrides
+	app.register(fs)
+	var svc serviceFlags
+	svc.register(fs)
+	var ns namespaceFlags
+	ns.register(fs)
+	var preflight preflightFlags
+	preflight.register(fs)
+	dryRun := registerDryRunFlag(fs)
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+
+	spec, kubeClient, err := g.appCommand(fs, app, *dryRun)
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	result := newDeployResult(kubeClient, spec, g.namespace)
+	defer g.printResult(result)
+	preflightOpts := PreflightOptions{Namespace: g.namespace, CreateNamespace: ns.create, Service: true}
+	if !preflight.run(kubeClient, spec, preflightOpts, result) {
+		return exitFailure
+	}
+	if err := ns.prepare(kubeClient, g.namespace, nil); err != nil {
+		return result.fail("", err)
+	}
+	result.Service, err = deployService(kubeClient, spec, g.namespace, svc)
+	if err != nil {
+		return result.fail("Error applying service: ", err)
+	}
+	return 0
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	"github.com/babbage88/infra-kubeinit/internal/diff"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Exit codes of the diff subcommand, matching kubectl diff.
+const (
+	exitDiffFound = 1
+	exitDiffError = 2
+)
+
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// volatileFields are set by the apiserver on every write and are left out of diffs.
+var volatileFields = [][]string{
+	{"metadata", "managedFields"},
+	{"metadata", "resourceVersion"},
+	{"metadata", "generation"},
+	{"metadata", "uid"},
+	{"metadata", "creationTimestamp"},
+	{"metadata", "selfLink"},
+	{"status"},
+}
+
+// diffableYAML renders obj without server-managed noise.
+func diffableYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	obj = obj.DeepCopy()
+	for _, field := range volatileFields {
+		unstructured.RemoveNestedField(obj.Object, field...)
+	}
+	data, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DiffObject compares the live object with the result of a server-side dry-run
+// apply of desired and returns a unified diff, empty when nothing would change.
+func (k *KubeClient) DiffObject(desired *unstructured.Unstructured, namespace string, opts ApplyOptions) (string, error) {
+	desired = desired.DeepCopy()
+	resourceClient, err := k.objectClient(desired, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := k.operationContext()
+	defer cancel()
+	live, err := resourceClient.Get(ctx, desired.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		live = nil
+	} else if err != nil {
+		return "", fmt.Errorf("error getting %s %s %w", desired.GetKind(), desired.GetName(), err)
+	}
+
+	// A deploy always bumps restartedAt; keep the live value so it doesn't show up as drift.
+	if live != nil && desired.GetKind() == "Deployment" {
+		restartedAt, found, _ := unstructured.NestedString(live.Object, "spec", "template", "metadata", "annotations", restartedAtAnnotation)
+		if found {
+			unstructured.SetNestedField(desired.Object, restartedAt, "spec", "template", "metadata", "annotations", restartedAtAnnotation)
+		}
+	}
+
+	dryRunClient := *k
+	dryRunClient.DryRun = DryRunServer
+	merged, err := dryRunClient.ApplyObject(desired, namespace, opts)
+	if err != nil {
+		return "", err
+	}
+
+	liveYAML, err := diffableYAML(live)
+	if err != nil {
+		return "", err
+	}
+	mergedYAML, err := diffableYAML(merged)
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s/%s", strings.ToLower(desired.GetKind()), desired.GetName())
+	return diff.Unified("live/"+name, "merged/"+name, liveYAML, mergedYAML, diff.DefaultContext), nil
+}
+
+// appSpecObjects renders the Deployment and Service kubeinit would apply for spec.
+func appSpecObjects(spec *appspec.AppSpec, namespace string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	deployment, err := toUnstructured(spec.Deployment(namespace))
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, deployment)
+
+	if service := spec.RenderService(namespace); service != nil {
+		obj, err := toUnstructured(service)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, nil
+}
+
+// objectDiff is the diff of one object, empty when applying would not change it.
+type objectDiff struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Changed   bool   `json:"changed"`
+	Diff      string `json:"diff,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// diffResult is the result of `kubeinit diff`.
+type diffResult struct {
+	Changed bool         `json:"changed"`
+	Objects []objectDiff `json:"objects"`
+}
+
+// printTable prints the unified diffs of the changed objects.
+func (r *diffResult) printTable() {
+	for _, obj := range r.Objects {
+		if obj.Changed {
+			pretty.PrintDiff(obj.Diff)
+		}
+	}
+}
+
+// runDiff implements `kubeinit diff`.
+func runDiff(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "diff", "[-config app.yaml] [app spec flags] [-f <file|dir> ...] [flags]",
+		fmt.Sprintf("Shows what applying would change, using a server-side dry-run apply. The app's\n"+
+			"Deployment and Service are built from -config and the app spec flags exactly as deploy\n"+
+			"builds them; with only -f manifests they are left out.\n"+
+			"Exits 0 when there are no differences, %d when there are, %d on errors.", exitDiffFound, exitDiffError))
+	var files stringsFlag
+	fs.Var(&files, "f", "Manifest file or directory to diff, repeatable")
+	var app appSpecOverrides
+	app.register(fs)
+	if !parseCommandFlags(g, fs, args) {
+		return exitDiffError
+	}
+	files = append(files, fs.Args()...)
+
+	var objects []*unstructured.Unstructured
+	if len(files) == 0 || appSpecFlagsSet(fs) {
+		spec, err := loadAppSpec(fs, app)
+		if err != nil {
+			pretty.PrintErrorf("Error loading app spec: %s", err.Error())
+			return exitDiffError
+		}
+		specObjects, err := appSpecObjects(spec, g.namespace)
+		if err != nil {
+			pretty.PrintErrorf("Error rendering app spec: %s", err.Error())
+			return exitDiffError
+		}
+		objects = append(objects, specObjects...)
+	}
+	if len(files) > 0 {
+		manifests, err := LoadManifests(files)
+		if err != nil {
+			pretty.PrintErrorf("Error loading manifests: %s", err.Error())
+			return exitDiffError
+		}
+		objects = append(objects, manifests...)
+	}
+
+	kubeClient, err := g.kubeClient()
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitDiffError
+	}
+
+	result := &diffResult{Objects: []objectDiff{}}
+	defer g.printResult(result)
+	exitCode := 0
+	for _, obj := range objects {
+		// Force so the diff shows the end state even where fields are owned by others.
+		d, err := kubeClient.DiffObject(obj, g.namespace, ApplyOptions{Force: true})
+		objDiff := objectDiff{Kind: obj.GetKind(), Name: obj.GetName(), Namespace: obj.GetNamespace(), Changed: d != "", Diff: d}
+		if err != nil {
+			pretty.PrintErrorf("%s", err.Error())
+			objDiff.Error = err.Error()
+			exitCode = exitDiffError
+		}
+		result.Objects = append(result.Objects, objDiff)
+		if d != "" {
+			result.Changed = true
+			if exitCode == 0 {
+				exitCode = exitDiffFound
+			}
+		}
+	}
+	return exitCode
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// jobsCommands are the subcommands of `kubeinit jobs`.
+var jobsCommands = []command{
+	{"history", "Show the database migration Jobs, oldest first", runJobsHistory},
+}
+
+var jobHistoryHeader = []string{"NAME", "TAG", "START", "COMPLETION", "DURATION", "SUCCEEDED", "FAILED", "CONDITION", "AGE"}
+
+// jobHistoryRow renders job as a table row. Running jobs show their duration so far.
+func jobHistoryRow(job JobSummary, now time.Time) []string {
+	elapsed := "<none>"
+	if job.Started != nil {
+		end := now
+		if job.Completed != nil {
+			end = job.Completed.Time
+		}
+		elapsed = duration.HumanDuration(end.Sub(job.Started.Time))
+	}
+	age := "<none>"
+	if !job.Created.IsZero() {
+		age = duration.HumanDuration(now.Sub(job.Created.Time))
+	}
+	condition := job.Status
+	if job.Reason != "" {
+		condition = fmt.Sprintf("%s (%s)", job.Status, job.Reason)
+	}
+	return []string{
+		job.Name,
+		orNone(job.Tag),
+		formatTime(job.Started),
+		formatTime(job.Completed),
+		elapsed,
+		strconv.Itoa(int(job.Succeeded)),
+		strconv.Itoa(int(job.Failed)),
+		condition,
+		age,
+	}
+}
+
+// printJobHistory prints jobs as a table, failed jobs as errors and running
+// ones as warnings.
+func printJobHistory(jobs []JobSummary) {
+	now := time.Now()
+	rows := make([][]string, 0, len(jobs))
+	for _, job := range jobs {
+		rows = append(rows, jobHistoryRow(job, now))
+	}
+	lines := formatTable(jobHistoryHeader, rows)
+	pretty.Printf("%s", lines[0])
+	for i, line := range lines[1:] {
+		printJobLine(jobs[i], line)
+	}
+}
+
+func printJobLine(job JobSummary, line string) {
+	switch job.Status {
+	case string(batchv1.JobFailed):
+		pretty.PrintErrorf("%s", line)
+	case string(batchv1.JobComplete):
+		pretty.Printf("%s", line)
+	default:
+		pretty.PrintWarningf("%s", line)
+	}
+}
+
+// sortJobHistory orders jobs by creation time, oldest first.
+func sortJobHistory(jobs []JobSummary) {
+	slices.SortStableFunc(jobs, func(a, b JobSummary) int {
+		if c := a.Created.Compare(b.Created.Time); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+}
+
+// jobHistoryResult is the result of `kubeinit jobs history`.
+type jobHistoryResult struct {
+	Namespace string       `json:"namespace"`
+	Selector  string       `json:"selector"`
+	Jobs      []JobSummary `json:"jobs"`
+	Error     string       `json:"error,omitempty"`
+}
+
+func (r *jobHistoryResult) printTable() {
+	if r.Error != "" {
+		return
+	}
+	if len(r.Jobs) == 0 {
+		pretty.Printf("No migration jobs found in namespace %s", r.Namespace)
+		return
+	}
+	printJobHistory(r.Jobs)
+}
+
+// update replaces, adds or removes job and keeps the jobs sorted.
+func (r *jobHistoryResult) update(job JobSummary, deleted bool) {
+	i := slices.IndexFunc(r.Jobs, func(j JobSummary) bool { return j.Name == job.Name })
+	switch {
+	case deleted && i >= 0:
+		r.Jobs = slices.Delete(r.Jobs, i, i+1)
+	case deleted:
+	case i >= 0:
+		r.Jobs[i] = job
+	default:
+		r.Jobs = append(r.Jobs, job)
+		sortJobHistory(r.Jobs)
+	}
+}
+
+// jobEvent is printed by `kubeinit jobs history -watch` for every change after
+// the initial history.
+type jobEvent struct {
+	Type watch.EventType `json:"type"`
+	Job  JobSummary      `json:"job"`
+}
+
+func (e *jobEvent) printTable() {
+	lines := formatTable(jobHistoryHeader, [][]string{jobHistoryRow(e.Job, time.Now())})
+	line := lines[1]
+	if e.Type == watch.Deleted {
+		line += "  (deleted)"
+	}
+	printJobLine(e.Job, line)
+}
+
+// runJobs implements `kubeinit jobs <command>`.
+func runJobs(g *globalOptions, args []string) int {
+	if len(args) > 0 {
+		for _, cmd := range jobsCommands {
+			if cmd.name == args[0] {
+				return cmd.run(g, args[1:])
+			}
+		}
+		if args[0] != "-h" && args[0] != "-help" {
+			pretty.PrintErrorf("Unknown jobs command %q", args[0])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s jobs <command> [flags]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, cmd := range jobsCommands {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", cmd.name, cmd.summary)
+	}
+	return exitUsage
+}
+
+// runJobsHistory implements `kubeinit jobs history`.
+func runJobsHistory(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "jobs history", "[flags]",
+		"Shows one row per database migration Job in the namespace, oldest first. With -watch\n"+
+			"the history is kept up to date until interrupted or -timeout elapses.")
+	app := fs.String("app", "", "Only show migration jobs of this app")
+	var selectors stringsFlag
+	fs.Var(&selectors, "l", "Additional label selector, e.g. infra-kubeinit/release=v1-2-2, repeatable")
+	watchJobs := fs.Bool("watch", false, "Keep running and show changes as they happen")
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+
+	kubeClient, err := g.kubeClient()
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	selector := MigrationHistorySelector(*app, "")
+	for _, s := range selectors {
+		selector = fmt.Sprintf("%s,%s", selector, s)
+	}
+
+	result := &jobHistoryResult{Namespace: g.namespace, Selector: selector, Jobs: []JobSummary{}}
+	jobs, err := kubeClient.GetBatchJobByLabel(g.namespace, selector)
+	if err != nil {
+		pretty.PrintErrorf("Error listing migration jobs: %s", err.Error())
+		result.Error = err.Error()
+		g.printResult(result)
+		return exitFailure
+	}
+	seen := make(map[string]string, len(jobs.Items))
+	for i := range jobs.Items {
+		result.Jobs = append(result.Jobs, summarizeJob(&jobs.Items[i]))
+		seen[jobs.Items[i].Name] = jobs.Items[i].ResourceVersion
+	}
+	sortJobHistory(result.Jobs)
+	g.printResult(result)
+	if !*watchJobs {
+		return 0
+	}
+
+	// On a terminal the table is redrawn as a whole, elsewhere each change is
+	// printed as a row or a document.
+	redraw := g.format == OutputTable && pretty.IsTerminal(pretty.Default().Writer())
+	err = kubeClient.WatchJobs(g.namespace, selector, func(job *batchv1.Job, deleted bool) {
+		version, known := seen[job.Name]
+		event := &jobEvent{Type: watch.Modified, Job: summarizeJob(job)}
+		switch {
+		case deleted && !known:
+			return
+		case deleted:
+			event.Type = watch.Deleted
+			delete(seen, job.Name)
+		case !known:
+			event.Type = watch.Added
+		case version == job.ResourceVersion:
+			// Already shown, the informer replays its initial list.
+			return
+		}
+		if !deleted {
+			seen[job.Name] = job.ResourceVersion
+		}
+
+		if redraw {
+			result.update(event.Job, deleted)
+			pretty.Default().ClearScreen()
+			result.printTable()
+			pretty.Printf("Watching for changes, last update %s. Press Ctrl-C to stop.", time.Now().Format(time.TimeOnly))
+			return
+		}
+		if g.format == OutputYAML {
+			fmt.Fprintln(g.stdout, "---")
+		}
+		g.printResult(event)
+	})
+	if err != nil {
+		pretty.PrintErrorf("Error watching migration jobs: %s", err.Error())
+		return exitFailure
+	}
+	return 0
+}
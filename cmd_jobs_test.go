@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSummarizeJob(t *testing.T) {
+	finished := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	job := newMigrationJob("migrate-1", "ghcr.io/babbage88/go-infra:v1.2.2", batchv1.JobFailed, finished)
+	job.CreationTimestamp = metav1.Time{Time: finished.Add(-2 * time.Minute)}
+	job.Status.CompletionTime = nil
+	job.Status.StartTime = &metav1.Time{Time: finished.Add(-90 * time.Second)}
+	job.Status.Conditions[0].Reason = "BackoffLimitExceeded"
+	job.Status.Failed = 2
+
+	got := summarizeJob(&job)
+	if got.Tag != "v1.2.2" {
+		t.Errorf("Tag = %q, want v1.2.2", got.Tag)
+	}
+	if got.Status != string(batchv1.JobFailed) || got.Reason != "BackoffLimitExceeded" {
+		t.Errorf("Status, Reason = %q, %q, want Failed, BackoffLimitExceeded", got.Status, got.Reason)
+	}
+	if got.Completed == nil || !got.Completed.Time.Equal(finished) {
+		t.Errorf("Completed = %v, want the condition transition time %v", got.Completed, finished)
+	}
+	if got.Duration != "1m30s" {
+		t.Errorf("Duration = %q, want 1m30s", got.Duration)
+	}
+
+	row := jobHistoryRow(got, finished.Add(time.Hour))
+	want := []string{"migrate-1", "v1.2.2", "2025-03-01T11:58:30Z", "2025-03-01T12:00:00Z", "90s", "0", "2", "Failed (BackoffLimitExceeded)", "62m"}
+	if !slices.Equal(row, want) {
+		t.Errorf("jobHistoryRow() = %q, want %q", row, want)
+	}
+}
+
+func TestJobHistoryResultUpdate(t *testing.T) {
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	job := func(name string, age time.Duration) JobSummary {
+		return JobSummary{Name: name, Created: metav1.Time{Time: created.Add(-age)}}
+	}
+	r := &jobHistoryResult{Jobs: []JobSummary{job("b", time.Hour), job("a", time.Hour), job("c", 2*time.Hour)}}
+	sortJobHistory(r.Jobs)
+	r.update(job("d", 30*time.Minute), false)
+	r.update(job("old", 3*time.Hour), false)
+	r.update(job("a", time.Hour), true)
+	r.update(job("missing", time.Hour), true)
+
+	var names []string
+	for _, j := range r.Jobs {
+		names = append(names, j.Name)
+	}
+	if want := []string{"old", "c", "b", "d"}; !slices.Equal(names, want) {
+		t.Errorf("jobs = %q, want %q", names, want)
+	}
+}
+
+func TestWatchJobs(t *testing.T) {
+	existing := newMigrationJob("migrate-1", "ghcr.io/babbage88/go-infra:v1.2.2", batchv1.JobComplete, time.Now())
+	k, clientset := newFakeKubeClient(t, &existing)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	k.Ctx = ctx
+
+	type change struct {
+		name    string
+		deleted bool
+	}
+	changes := make(chan change, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- k.WatchJobs("default", MigrationHistorySelector("go-infra", ""), func(job *batchv1.Job, deleted bool) {
+			changes <- change{job.Name, deleted}
+		})
+	}()
+
+	next := func() change {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for a job change")
+			return change{}
+		}
+	}
+	if c := next(); c != (change{"migrate-1", false}) {
+		t.Fatalf("first change = %+v, want the existing job", c)
+	}
+
+	added := newMigrationJob("migrate-2", "ghcr.io/babbage88/go-infra:v1.3.0", batchv1.JobComplete, time.Now())
+	if _, err := clientset.BatchV1().Jobs("default").Create(ctx, &added, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if c := next(); c != (change{"migrate-2", false}) {
+		t.Fatalf("change = %+v, want migrate-2 added", c)
+	}
+	if err := clientset.BatchV1().Jobs("default").Delete(ctx, "migrate-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if c := next(); c != (change{"migrate-1", true}) {
+		t.Fatalf("change = %+v, want migrate-1 deleted", c)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchJobs() error = %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+)
+
+// migrationFlags holds the flags controlling the migration Job, shared by the
+// migrate and deploy commands.
+type migrationFlags struct {
+	timeout        time.Duration
+	followLogs     bool
+	tailLines      int64
+	rerunAfter     time.Duration
+	releaseVersion string
+}
+
+func (m *migrationFlags) register(fs *flag.FlagSet) {
+	fs.DurationVar(&m.timeout, "migration-timeout", 5*time.Minute, "How long to wait for the DB migration job to finish")
+	fs.BoolVar(&m.followLogs, "follow-logs", true, "Stream DB migration job pod logs while it runs")
+	fs.Int64Var(&m.tailLines, "log-tail-lines", 20, "Lines of migration job logs to include when it fails")
+	fs.DurationVar(&m.rerunAfter, "migration-rerun-after", 0, "Rerun the migration when the last success is older than this even if the image is unchanged, 0 disables")
+	fs.StringVar(&m.releaseVersion, "release-version", "", "Release recorded on the migration job, defaults to the -image-name tag")
+}
+
+// options builds the MigrationOptions for running spec's migration in namespace.
+func (m *migrationFlags) options(spec *appspec.AppSpec, namespace string) MigrationOptions {
+	opts := MigrationOptions{
+		Spec:       spec,
+		Namespace:  namespace,
+		Version:    m.releaseVersion,
+		Timeout:    m.timeout,
+		FollowLogs: m.followLogs,
+		TailLines:  m.tailLines,
+		RerunAfter: m.rerunAfter,
+	}
+	if opts.Version == "" {
+		opts.Version = imageTag(spec.Containers[0].Image)
+	}
+	if spec.Migration != nil {
+		opts.Image = spec.Migration.Container.Image
+	}
+	return opts
+}
+
+// appCommand loads the app spec from the parsed app flags and creates a kube
+// client with the requested dry-run mode.
+func (g *globalOptions) appCommand(fs *flag.FlagSet, app appSpecOverrides, dryRun string) (*appspec.AppSpec, *KubeClient, error) {
+	spec, err := loadAppSpec(fs, app)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading app spec %w", err)
+	}
+	mode, err := ParseDryRunMode(dryRun)
+	if err != nil {
+		return nil, nil, err
+	}
+	kubeClient, err := g.kubeClient(WithDryRun(mode))
+	if err != nil {
+		return nil, nil, err
+	}
+	return spec, kubeClient, nil
+}
+
+// runMigrate implements `kubeinit migrate`.
+func runMigrate(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "migrate", "[flags]",
+		"Runs the app's database migration Job and waits for it to finish. The migration is\n"+
+			"skipped when the latest successful migration Job ran the same image.")
+	var app appSpecOverrides
+	app.register(fs)
+	var migration migrationFlags
+	migration.register(fs)
+	var ns namespaceFlags
+	ns.register(fs)
+	var preflight preflightFlags
+	preflight.register(fs)
+	dryRun := registerDryRunFlag(fs)
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+
+	spec, kubeClient, err := g.appCommand(fs, app, *dryRun)
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	result := newDeployResult(kubeClient, spec, g.namespace)
+	defer g.printResult(result)
+	preflightOpts := PreflightOptions{Namespace: g.namespace, CreateNamespace: ns.create, Migrate: true, SkipSecrets: ns.skipSecretCheck}
+	if !preflight.run(kubeClient, spec, preflightOpts, result) {
+		return exitFailure
+	}
+	if err := ns.prepare(kubeClient, g.namespace, preflight.uncheckedSecrets(spec)); err != nil {
+		return result.fail("", err)
+	}
+	result.Migration, err = kubeClient.PrepDeployment(migration.options(spec, g.namespace))
+	if err != nil {
+		return result.fail("Error running migration: ", err)
+	}
+	return 0
+}
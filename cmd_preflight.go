@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+)
+
+// preflightResult is the result of `kubeinit preflight`.
+type preflightResult struct {
+	Namespace string           `json:"namespace"`
+	Passed    bool             `json:"passed"`
+	Checks    []PreflightCheck `json:"checks"`
+}
+
+func (r *preflightResult) printTable() {
+	printPreflightTable(r.Checks)
+}
+
+// printPreflightTable prints the checks as a table, colored by status.
+func printPreflightTable(checks []PreflightCheck) {
+	rows := make([][]string, 0, len(checks))
+	for _, check := range checks {
+		rows = append(rows, []string{check.Name, string(check.Status), check.Message})
+	}
+	lines := formatTable([]string{"CHECK", "RESULT", "DETAILS"}, rows)
+	pretty.Printf("%s", lines[0])
+	for i, line := range lines[1:] {
+		switch checks[i].Status {
+		case PreflightFail:
+			pretty.PrintErrorf("%s", line)
+		case PreflightWarn:
+			pretty.PrintWarningf("%s", line)
+		default:
+			pretty.Printf("%s", line)
+		}
+	}
+}
+
+// preflightFlags lets the migrate, deploy and service commands skip preflight.
+type preflightFlags struct {
+	skip bool
+}
+
+func (p *preflightFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&p.skip, "skip-preflight", false, "Do not run preflight checks before changing the cluster")
+}
+
+// run prints the preflight report unless skipped, records the checks in result
+// and reports whether the command may continue.
+func (p *preflightFlags) run(kubeClient *KubeClient, spec *appspec.AppSpec, opts PreflightOptions, result *deployResult) bool {
+	if p.skip {
+		return true
+	}
+	result.Preflight = kubeClient.Preflight(spec, opts)
+	printPreflightTable(result.Preflight)
+	if PreflightFailed(result.Preflight) {
+		pretty.PrintErrorf("Preflight checks failed, nothing was changed")
+		result.Error = "preflight checks failed"
+		return false
+	}
+	return true
+}
+
+// uncheckedSecrets returns the secrets still to verify after preflight, which
+// already checked them unless skipped.
+func (p *preflightFlags) uncheckedSecrets(spec *appspec.AppSpec) []string {
+	if p.skip {
+		return spec.SecretNames()
+	}
+	return nil
+}
+
+// runPreflight implements `kubeinit preflight`.
+func runPreflight(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "preflight", "[flags]",
+		"Checks the cluster is reachable, RBAC allows everything a deploy does, referenced\n"+
+			"secrets exist and resource requests fit the namespace LimitRanges and ResourceQuotas.\n"+
+			"Exits non-zero when a check fails. Nothing is changed.")
+	var app appSpecOverrides
+	app.register(fs)
+	createNamespace := fs.Bool("create-namespace", false, "Check as if the namespace would be created when missing")
+	skipMigration := fs.Bool("skip-migration", false, "Do not check the database migration")
+	skipService := fs.Bool("skip-service", false, "Do not check the Service")
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+
+	spec, kubeClient, err := g.appCommand(fs, app, string(DryRunNone))
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	checks := kubeClient.Preflight(spec, PreflightOptions{
+		Namespace:       g.namespace,
+		CreateNamespace: *createNamespace,
+		Migrate:         !*skipMigration,
+		Deploy:          true,
+		Service:         !*skipService,
+	})
+	result := &preflightResult{Namespace: g.namespace, Passed: !PreflightFailed(checks), Checks: checks}
+	g.printResult(result)
+	if !result.Passed {
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Rollout states reported in deploymentResult.Rollout.
+const (
+	rolloutComplete    = "complete"
+	rolloutProgressing = "progressing"
+	rolloutFailed      = "failed"
+	rolloutRolledBack  = "rolled-back"
+	rolloutDryRun      = "dry-run"
+)
+
+// containerImage is the image a Deployment runs in one of its containers.
+type containerImage struct {
+	Name  string `json:"name"`
+	Image string `json:"image"`
+}
+
+// deploymentResult is the rollout state of the app's Deployment.
+type deploymentResult struct {
+	Name         string           `json:"name"`
+	Revision     string           `json:"revision,omitempty"`
+	Replicas     int32            `json:"replicas"`
+	Ready        int32            `json:"ready"`
+	Updated      int32            `json:"updated"`
+	Available    int32            `json:"available"`
+	Containers   []containerImage `json:"containers,omitempty"`
+	Rollout      string           `json:"rollout"`
+	Message      string           `json:"message,omitempty"`
+	LastRollback string           `json:"lastRollback,omitempty"`
+}
+
+func newDeploymentResult(deployment *appsv1.Deployment) *deploymentResult {
+	result := &deploymentResult{
+		Name:         deployment.Name,
+		Revision:     deployment.Annotations[revisionAnnotation],
+		Replicas:     1,
+		Ready:        deployment.Status.ReadyReplicas,
+		Updated:      deployment.Status.UpdatedReplicas,
+		Available:    deployment.Status.AvailableReplicas,
+		LastRollback: deployment.Annotations[rollbackAnnotation],
+	}
+	if deployment.Spec.Replicas != nil {
+		result.Replicas = *deployment.Spec.Replicas
+	}
+	for _, c := range deployment.Spec.Template.Spec.Containers {
+		result.Containers = append(result.Containers, containerImage{Name: c.Name, Image: c.Image})
+	}
+
+	message, done, err := rolloutStatus(deployment)
+	switch {
+	case err != nil:
+		result.Rollout, result.Message = rolloutFailed, err.Error()
+	case done:
+		result.Rollout, result.Message = rolloutComplete, message
+	default:
+		result.Rollout, result.Message = rolloutProgressing, message
+	}
+	return result
+}
+
+func (r *deploymentResult) row() []string {
+	details := []string{fmt.Sprintf("revision %s, %d/%d ready, %d updated, %d available",
+		orNone(r.Revision), r.Ready, r.Replicas, r.Updated, r.Available)}
+	for _, c := range r.Containers {
+		details = append(details, fmt.Sprintf("%s %s", c.Name, c.Image))
+	}
+	if r.Rollout != rolloutComplete && r.Message != "" {
+		details = append(details, r.Message)
+	}
+	return []string{"deployment/" + r.Name, r.Rollout, strings.Join(details, ", ")}
+}
+
+// serviceResult is the type and addresses of the app's Service.
+type serviceResult struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	ClusterIP string   `json:"clusterIP,omitempty"`
+	External  []string `json:"external"`
+}
+
+func newServiceResult(service *corev1.Service) *serviceResult {
+	result := &serviceResult{
+		Name:      service.Name,
+		Type:      string(service.Spec.Type),
+		ClusterIP: service.Spec.ClusterIP,
+		External:  []string{},
+	}
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			result.External = append(result.External, ingress.IP)
+		} else if ingress.Hostname != "" {
+			result.External = append(result.External, ingress.Hostname)
+		}
+	}
+	return result
+}
+
+func (r *serviceResult) row() []string {
+	details := "external " + orNone(strings.Join(r.External, ","))
+	if r.ClusterIP != "" {
+		details = fmt.Sprintf("cluster IP %s, %s", r.ClusterIP, details)
+	}
+	return []string{"service/" + r.Name, r.Type, details}
+}
+
+func migrationJobRow(job *JobSummary) []string {
+	return []string{"job/" + job.Name, job.Status, fmt.Sprintf("image %s, completed %s", job.Image, formatTime(job.Completed))}
+}
+
+// printResources prints the deployment, service and migration job rows that are set.
+func printResources(deployment *deploymentResult, service *serviceResult, job *JobSummary) {
+	var rows [][]string
+	if deployment != nil {
+		rows = append(rows, deployment.row())
+	}
+	if service != nil {
+		rows = append(rows, service.row())
+	}
+	if job != nil {
+		rows = append(rows, migrationJobRow(job))
+	}
+	if len(rows) > 0 {
+		printTable([]string{"RESOURCE", "STATUS", "DETAILS"}, rows)
+	}
+	if deployment != nil && deployment.LastRollback != "" {
+		pretty.PrintWarningf("Last rollback: %s", deployment.LastRollback)
+	}
+}
+
+// statusResult is the result of `kubeinit status`.
+type statusResult struct {
+	App             string            `json:"app"`
+	Namespace       string            `json:"namespace"`
+	Deployment      *deploymentResult `json:"deployment,omitempty"`
+	Service         *serviceResult    `json:"service,omitempty"`
+	LatestMigration *JobSummary       `json:"latestMigration,omitempty"`
+	Errors          []string          `json:"errors,omitempty"`
+}
+
+func (r *statusResult) printTable() {
+	printResources(r.Deployment, r.Service, r.LatestMigration)
+}
+
+// runStatus implements `kubeinit status`.
+func runStatus(g *globalOptions, args []string) int {
+	fs := newCommandFlagSet(g, "status", "[flags]",
+		"Shows the Deployment's rollout progress, the Service address and the latest\n"+
+			"successful migration Job. Exits non-zero when the Deployment is missing or its rollout failed.")
+	var app appSpecOverrides
+	app.register(fs)
+	if !parseCommandFlags(g, fs, args) {
+		return exitUsage
+	}
+
+	spec, kubeClient, err := g.appCommand(fs, app, string(DryRunNone))
+	if err != nil {
+		pretty.PrintErrorf("%s", err.Error())
+		return exitFailure
+	}
+	result := &statusResult{App: spec.Name, Namespace: g.namespace}
+	defer g.printResult(result)
+	exitCode := 0
+	fail := func(err error) {
+		pretty.PrintErrorf("%s", err.Error())
+		result.Errors = append(result.Errors, err.Error())
+		exitCode = exitFailure
+	}
+
+	deployment, err := kubeClient.GetDeployment(g.namespace, spec.Name)
+	if err != nil {
+		fail(err)
+	} else {
+		result.Deployment = newDeploymentResult(deployment)
+		switch result.Deployment.Rollout {
+		case rolloutFailed:
+			fail(fmt.Errorf("%s", result.Deployment.Message))
+		case rolloutProgressing:
+			pretty.PrintWarningf("Rollout in progress: %s", result.Deployment.Message)
+		}
+	}
+
+	if service := spec.RenderService(g.namespace); service != nil {
+		ctx, cancel := kubeClient.operationContext()
+		live, err := kubeClient.Client.CoreV1().Services(g.namespace).Get(ctx, service.Name, metav1.GetOptions{})
+		cancel()
+		switch {
+		case apierrors.IsNotFound(err):
+			pretty.PrintWarningf("Service %s does not exist", service.Name)
+		case err != nil:
+			fail(fmt.Errorf("error getting service %s %w", service.Name, err))
+		default:
+			result.Service = newServiceResult(live)
+		}
+	}
+
+	if spec.Migration != nil {
+		jobs, err := kubeClient.GetBatchJobByLabel(g.namespace, MigrationHistorySelector(spec.Name, ""))
+		if err != nil {
+			fail(fmt.Errorf("error listing migration jobs %w", err))
+			return exitCode
+		}
+		if latest := getLatestSuccessfulJob(jobs.Items); latest != nil {
+			summary := summarizeJob(latest)
+			result.LatestMigration = &summary
+		} else {
+			pretty.PrintWarning("No successful migration job found")
+		}
+	}
+	return exitCode
+}
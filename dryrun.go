@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+)
+
+// DryRunMode selects whether mutating calls reach the cluster.
+type DryRunMode string
+
+const (
+	// DryRunNone sends every mutation to the cluster.
+	DryRunNone DryRunMode = "none"
+	// DryRunClient prints the objects that would be sent without contacting the cluster.
+	DryRunClient DryRunMode = "client"
+	// DryRunServer sends mutations with dryRun=All so the apiserver validates
+	// and defaults them without persisting anything.
+	DryRunServer DryRunMode = "server"
+)
+
+func ParseDryRunMode(s string) (DryRunMode, error) {
+	switch DryRunMode(s) {
+	case "", DryRunNone:
+		return DryRunNone, nil
+	case DryRunClient, DryRunServer:
+		return DryRunMode(s), nil
+	}
+	return DryRunNone, fmt.Errorf("invalid dry-run mode %q, expected none, client or server", s)
+}
+
+func WithDryRun(mode DryRunMode) KubeClientOption {
+	return func(k *KubeClient) {
+		k.DryRun = mode
+	}
+}
+
+// dryRunning reports whether mutations are simulated.
+func (k *KubeClient) dryRunning() bool {
+	return k.DryRun == DryRunClient || k.DryRun == DryRunServer
+}
+
+// serverDryRun returns the dryRun value for mutating requests.
+func (k *KubeClient) serverDryRun() []string {
+	if k.DryRun == DryRunServer {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+func (k *KubeClient) createOptions() metav1.CreateOptions {
+	return metav1.CreateOptions{FieldManager: fieldManager, DryRun: k.serverDryRun()}
+}
+
+func (k *KubeClient) updateOptions() metav1.UpdateOptions {
+	return metav1.UpdateOptions{FieldManager: fieldManager, DryRun: k.serverDryRun()}
+}
+
+func (k *KubeClient) patchOptions() metav1.PatchOptions {
+	return metav1.PatchOptions{FieldManager: fieldManager, DryRun: k.serverDryRun()}
+}
+
+func (k *KubeClient) applyOptions(opts ApplyOptions) metav1.ApplyOptions {
+	applyOpts := opts.patchOptions()
+	applyOpts.DryRun = k.serverDryRun()
+	return applyOpts
+}
+
+// clientDryRun prints obj and returns true when running in client dry-run mode,
+// in which case the caller must not send the request.
+func (k *KubeClient) clientDryRun(verb string, obj runtime.Object) bool {
+	if k.DryRun != DryRunClient {
+		return false
+	}
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		pretty.PrintErrorf("Error rendering dry-run object: %s", err.Error())
+		return true
+	}
+	pretty.Printf("# dry-run (client): would %s", verb)
+	fmt.Fprintln(pretty.Default().Writer(), string(data))
+	return true
+}
+
+// registerDryRunFlag adds the -dry-run flag to fs.
+func registerDryRunFlag(fs *flag.FlagSet) *string {
+	return fs.String("dry-run", string(DryRunNone), "none, client (print objects only) or server (validate with the apiserver without persisting)")
+}
@@ -0,0 +1,196 @@
+//go:build integration
+
+// The integration suite runs against a real kube-apiserver and etcd started by
+// envtest. Install the binaries and point KUBEBUILDER_ASSETS at them:
+//
+//	go install sigs.k8s.io/controller-runtime/tools/setup-envtest@latest
+//	export KUBEBUILDER_ASSETS=$(setup-envtest use -p path 1.32.x)
+//	go test -tags integration ./...
+//
+// Only the API server runs, so no controllers create pods or finish jobs; tests
+// set the status a controller would.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// envtestConfig is the config of the shared API server, nil when the suite is skipped.
+var envtestConfig *rest.Config
+
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		os.Exit(m.Run())
+	}
+
+	env := &envtest.Environment{}
+	config, err := env.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error starting envtest API server: %v\n", err)
+		os.Exit(1)
+	}
+	envtestConfig = config
+	code := m.Run()
+	if err := env.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "error stopping envtest API server: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// newEnvtestKubeClient returns a client for the envtest API server working in a
+// fresh namespace.
+func newEnvtestKubeClient(t *testing.T) (*KubeClient, string) {
+	t.Helper()
+	if envtestConfig == nil {
+		t.Skip("KUBEBUILDER_ASSETS is not set, see setup-envtest")
+	}
+	k := NewKubeClient(WithOperationTimeout(10 * time.Second))
+	if err := k.initializeClients(envtestConfig); err != nil {
+		t.Fatalf("initializeClients() error = %v", err)
+	}
+
+	namespace := fmt.Sprintf("kubeinit-%d", time.Now().UnixNano())
+	if err := k.EnsureNamespace(namespace, map[string]string{"purpose": "integration"}); err != nil {
+		t.Fatalf("EnsureNamespace() error = %v", err)
+	}
+	return k, namespace
+}
+
+func TestIntegrationPreflight(t *testing.T) {
+	k, namespace := newEnvtestKubeClient(t)
+	spec := appspec.Default("go-infra", "ghcr.io/babbage88/go-infra:v1.2.2", newImage, 8993, 1)
+	checks := k.Preflight(spec, PreflightOptions{Namespace: namespace, Migrate: true, Deploy: true, Service: true, SkipSecrets: true})
+	for _, check := range checks {
+		if check.Status == PreflightFail {
+			t.Errorf("check %s failed: %s", check.Name, check.Message)
+		}
+	}
+}
+
+func TestIntegrationService(t *testing.T) {
+	k, namespace := newEnvtestKubeClient(t)
+	service := newTestService(corev1.ServiceTypeNodePort)
+	service.Namespace = namespace
+	if err := k.CreateOrUpdateService(service); err != nil {
+		t.Fatalf("create: CreateOrUpdateService() error = %v", err)
+	}
+	servicesClient := k.Client.CoreV1().Services(namespace)
+	created, err := servicesClient.Get(context.Background(), service.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired := newTestService(corev1.ServiceTypeNodePort)
+	desired.Namespace = namespace
+	desired.Labels["release"] = "v1-2-2"
+	if err := k.CreateOrUpdateService(desired); err != nil {
+		t.Fatalf("update: CreateOrUpdateService() error = %v", err)
+	}
+	updated, err := servicesClient.Get(context.Background(), service.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Spec.ClusterIP != created.Spec.ClusterIP {
+		t.Errorf("clusterIP changed from %s to %s", created.Spec.ClusterIP, updated.Spec.ClusterIP)
+	}
+	if updated.Spec.Ports[0].NodePort != created.Spec.Ports[0].NodePort {
+		t.Errorf("nodePort changed from %d to %d", created.Spec.Ports[0].NodePort, updated.Spec.Ports[0].NodePort)
+	}
+	if updated.Labels["release"] != "v1-2-2" {
+		t.Errorf("labels = %v, want release=v1-2-2", updated.Labels)
+	}
+}
+
+func TestIntegrationDeploymentApply(t *testing.T) {
+	k, namespace := newEnvtestKubeClient(t)
+	spec := appspec.Default("go-infra", "ghcr.io/babbage88/go-infra:v1.2.2", newImage, 8993, 1)
+	if err := k.CreateOrUpdateDeployment(&namespace, spec, false, ApplyOptions{}); err != nil {
+		t.Fatalf("first apply error = %v", err)
+	}
+
+	spec.Containers[0].Image = "ghcr.io/babbage88/go-infra:v1.2.3"
+	if err := k.CreateOrUpdateDeployment(&namespace, spec, false, ApplyOptions{}); err != nil {
+		t.Fatalf("second apply error = %v", err)
+	}
+	deployment, err := k.Client.AppsV1().Deployments(namespace).Get(context.Background(), spec.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := deployment.Spec.Template.Spec.Containers[0].Image; got != spec.Containers[0].Image {
+		t.Errorf("image = %s, want %s", got, spec.Containers[0].Image)
+	}
+	managed := false
+	for _, entry := range deployment.ManagedFields {
+		managed = managed || entry.Manager == fieldManager
+	}
+	if !managed {
+		t.Errorf("no managed fields entry for %s", fieldManager)
+	}
+}
+
+func TestIntegrationMigration(t *testing.T) {
+	k, namespace := newEnvtestKubeClient(t)
+	spec := appspec.Default("go-infra", "ghcr.io/babbage88/go-infra:v1.2.2", newImage, 8993, 1)
+	opts := MigrationOptions{Spec: spec, Namespace: namespace, Image: newImage, Version: "v1.2.2", Timeout: 30 * time.Second}
+
+	// Record a finished migration for the image the way the Job controller would.
+	labels, annotations := migrationJobMetadata(spec.Name, newImage, "v1-2-2")
+	if err := k.CreateBatchJob("init-db-v1-2-2", namespace, spec, labels, annotations); err != nil {
+		t.Fatalf("CreateBatchJob() error = %v", err)
+	}
+	jobsClient := k.Client.BatchV1().Jobs(namespace)
+	job, err := jobsClient.Get(context.Background(), "init-db-v1-2-2", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := metav1.Now()
+	job.Status.StartTime = &now
+	job.Status.CompletionTime = &now
+	job.Status.Succeeded = 1
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobSuccessCriteriaMet, Status: corev1.ConditionTrue, LastTransitionTime: now},
+		{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: now},
+	}
+	if _, err := jobsClient.UpdateStatus(context.Background(), job, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("UpdateStatus() error = %v", err)
+	}
+
+	result, err := k.PrepDeployment(opts)
+	if err != nil {
+		t.Fatalf("PrepDeployment() error = %v", err)
+	}
+	if result.Run {
+		t.Errorf("migration ran for an unchanged image: %s", result.Reason)
+	}
+	jobs, err := jobsClient.List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs.Items) != 1 {
+		t.Errorf("found %d jobs after an unchanged image, want 1", len(jobs.Items))
+	}
+
+	// A new image needs a migration; server dry-run validates the Job without
+	// creating it, since nothing would run it here.
+	k.DryRun = DryRunServer
+	opts.Image = "ghcr.io/babbage88/init-infradb:v1.2.3"
+	opts.Version = "v1.2.3"
+	result, err = k.PrepDeployment(opts)
+	if err != nil {
+		t.Fatalf("PrepDeployment() with a new image error = %v", err)
+	}
+	if result.Status != migrationDryRun {
+		t.Errorf("status = %q, want %q", result.Status, migrationDryRun)
+	}
+}
@@ -0,0 +1,202 @@
+package appspec
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// AppSpec describes an application deployed by kubeinit: the Deployment's
+// containers, the optional database migration Job run before it and the
+// optional Service exposing it.
+type AppSpec struct {
+	Name             string            `json:"name"`
+	Replicas         *int32            `json:"replicas,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	ImagePullSecrets []string          `json:"imagePullSecrets,omitempty"`
+	Containers       []Container       `json:"containers"`
+	Migration        *Migration        `json:"migration,omitempty"`
+	Service          *ServiceSpec      `json:"service,omitempty"`
+}
+
+// Container describes a single container. Env and Resources use the Kubernetes
+// field names so snippets can be copied from existing manifests.
+type Container struct {
+	Name            string                      `json:"name,omitempty"`
+	Image           string                      `json:"image"`
+	ImagePullPolicy corev1.PullPolicy           `json:"imagePullPolicy,omitempty"`
+	Command         []string                    `json:"command,omitempty"`
+	Args            []string                    `json:"args,omitempty"`
+	Ports           []corev1.ContainerPort      `json:"ports,omitempty"`
+	Env             []corev1.EnvVar             `json:"env,omitempty"`
+	EnvFromSecrets  []string                    `json:"envFromSecrets,omitempty"`
+	SecretFiles     []SecretFile                `json:"secretFiles,omitempty"`
+	Resources       corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+// SecretFile mounts a single key of a Secret as a file at MountPath.
+type SecretFile struct {
+	Secret    string `json:"secret"`
+	Key       string `json:"key"`
+	MountPath string `json:"mountPath"`
+}
+
+// Migration describes the Job that runs database migrations before a deploy.
+// Finished Jobs are the history used to skip migrations that already ran, so
+// TTLSecondsAfterFinished is best left unset and old Jobs pruned by cleanup.
+type Migration struct {
+	Container               Container            `json:"container"`
+	TTLSecondsAfterFinished *int32               `json:"ttlSecondsAfterFinished,omitempty"`
+	BackoffLimit            *int32               `json:"backoffLimit,omitempty"`
+	RestartPolicy           corev1.RestartPolicy `json:"restartPolicy,omitempty"`
+}
+
+// Load reads an AppSpec from a YAML or JSON file.
+func Load(path string) (*AppSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading app spec %s %w", path, err)
+	}
+
+	spec := &AppSpec{}
+	if err := yaml.UnmarshalStrict(data, spec); err != nil {
+		return nil, fmt.Errorf("error parsing app spec %s %w", path, err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid app spec %s %w", path, err)
+	}
+	return spec, nil
+}
+
+// Validate checks the fields required to render a Deployment and migration Job.
+func (s *AppSpec) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(s.Containers) == 0 {
+		return fmt.Errorf("at least one container is required")
+	}
+	for i, c := range s.Containers {
+		if err := c.validate(); err != nil {
+			return fmt.Errorf("containers[%d]: %w", i, err)
+		}
+	}
+	if s.Migration != nil {
+		if err := s.Migration.Container.validate(); err != nil {
+			return fmt.Errorf("migration.container: %w", err)
+		}
+		switch s.Migration.RestartPolicy {
+		case "", corev1.RestartPolicyOnFailure, corev1.RestartPolicyNever:
+		default:
+			return fmt.Errorf("migration.restartPolicy must be OnFailure or Never, got %q", s.Migration.RestartPolicy)
+		}
+	}
+	if s.Service != nil {
+		if err := s.Service.validate(); err != nil {
+			return fmt.Errorf("service: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Container) validate() error {
+	if c.Image == "" {
+		return fmt.Errorf("image is required")
+	}
+	for i, f := range c.SecretFiles {
+		if f.Secret == "" || f.Key == "" || f.MountPath == "" {
+			return fmt.Errorf("secretFiles[%d]: secret, key and mountPath are required", i)
+		}
+	}
+	return nil
+}
+
+// SecretNames returns every Secret the spec references, including image pull secrets.
+func (s *AppSpec) SecretNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	containers := s.Containers
+	if s.Migration != nil {
+		containers = append(containers[:len(containers):len(containers)], s.Migration.Container)
+	}
+	for _, c := range containers {
+		for _, f := range c.SecretFiles {
+			add(f.Secret)
+		}
+		for _, name := range c.EnvFromSecrets {
+			add(name)
+		}
+	}
+	for _, name := range s.ImagePullSecrets {
+		add(name)
+	}
+	return names
+}
+
+// volumeName derives a valid volume name from a Secret name, e.g. initdb.env -> initdb-env.
+func volumeName(secret string) string {
+	return strings.ReplaceAll(secret, ".", "-")
+}
+
+func defaultResources() corev1.ResourceRequirements {
+	return corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+			corev1.ResourceCPU:    resource.MustParse("250m"),
+		},
+	}
+}
+
+// Default returns the go-infra spec kubeinit used before app spec files existed.
+func Default(name string, image string, migrationImage string, containerPort int32, replicas int32) *AppSpec {
+	return &AppSpec{
+		Name:             name,
+		Replicas:         &replicas,
+		ImagePullSecrets: []string{"ghcr"},
+		Containers: []Container{
+			{
+				Name:            name,
+				Image:           image,
+				ImagePullPolicy: corev1.PullAlways,
+				Command:         []string{"/app/server"},
+				Ports:           []corev1.ContainerPort{{ContainerPort: containerPort}},
+				SecretFiles: []SecretFile{
+					{Secret: "cf-token-ini", Key: "cf_token.ini", MountPath: "/run/secrets/cf_token.ini"},
+					{Secret: "k3s-env", Key: "k3s.env", MountPath: "/app/.env"},
+				},
+				Resources: defaultResources(),
+			},
+		},
+		Migration: &Migration{
+			Container: Container{
+				Image:           migrationImage,
+				ImagePullPolicy: corev1.PullAlways,
+				Command:         []string{"/app/migrate"},
+				SecretFiles: []SecretFile{
+					{Secret: "initdb.env", Key: ".env", MountPath: "/app/.env"},
+				},
+				Resources: defaultResources(),
+			},
+			RestartPolicy: corev1.RestartPolicyOnFailure,
+		},
+		Service: &ServiceSpec{
+			Name: name + "-svc",
+			Type: corev1.ServiceTypeLoadBalancer,
+		},
+	}
+}
@@ -0,0 +1,158 @@
+package appspec
+
+import (
+	"maps"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SelectorLabels are the labels a Deployment uses to select its pods.
+func (s *AppSpec) SelectorLabels() map[string]string {
+	return map[string]string{"app": s.Name}
+}
+
+func (s *AppSpec) podLabels() map[string]string {
+	labels := make(map[string]string, len(s.Labels)+1)
+	maps.Copy(labels, s.Labels)
+	maps.Copy(labels, s.SelectorLabels())
+	return labels
+}
+
+// Deployment renders the Deployment for the spec.
+func (s *AppSpec) Deployment(namespace string) *appsv1.Deployment {
+	containers := make([]corev1.Container, 0, len(s.Containers))
+	for _, c := range s.Containers {
+		name := c.Name
+		if name == "" {
+			name = s.Name
+		}
+		containers = append(containers, c.render(name))
+	}
+
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.Name,
+			Namespace: namespace,
+			Labels:    s.podLabels(),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: s.Replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: s.SelectorLabels(),
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: s.podLabels(),
+				},
+				Spec: corev1.PodSpec{
+					Containers:       containers,
+					Volumes:          secretVolumes(s.Containers),
+					ImagePullSecrets: s.pullSecrets(),
+				},
+			},
+		},
+	}
+}
+
+// MigrationJob renders the migration Job for the spec. The caller supplies the
+// Job name and the labels/annotations used to trace it. It returns nil when the
+// spec has no migration.
+func (s *AppSpec) MigrationJob(name string, namespace string, labels map[string]string, annotations map[string]string) *batchv1.Job {
+	if s.Migration == nil {
+		return nil
+	}
+
+	restartPolicy := s.Migration.RestartPolicy
+	if restartPolicy == "" {
+		restartPolicy = corev1.RestartPolicyOnFailure
+	}
+	containerName := s.Migration.Container.Name
+	if containerName == "" {
+		containerName = name
+	}
+
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: batchv1.JobSpec{
+			TTLSecondsAfterFinished: s.Migration.TTLSecondsAfterFinished,
+			BackoffLimit:            s.Migration.BackoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: annotations,
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy:    restartPolicy,
+					Containers:       []corev1.Container{s.Migration.Container.render(containerName)},
+					Volumes:          secretVolumes([]Container{s.Migration.Container}),
+					ImagePullSecrets: s.pullSecrets(),
+				},
+			},
+		},
+	}
+}
+
+func (s *AppSpec) pullSecrets() []corev1.LocalObjectReference {
+	var refs []corev1.LocalObjectReference
+	for _, name := range s.ImagePullSecrets {
+		refs = append(refs, corev1.LocalObjectReference{Name: name})
+	}
+	return refs
+}
+
+func (c *Container) render(name string) corev1.Container {
+	container := corev1.Container{
+		Name:            name,
+		Image:           c.Image,
+		ImagePullPolicy: c.ImagePullPolicy,
+		Command:         c.Command,
+		Args:            c.Args,
+		Ports:           c.Ports,
+		Env:             c.Env,
+		Resources:       c.Resources,
+	}
+	for _, secret := range c.EnvFromSecrets {
+		container.EnvFrom = append(container.EnvFrom, corev1.EnvFromSource{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secret}},
+		})
+	}
+	for _, f := range c.SecretFiles {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volumeName(f.Secret),
+			MountPath: f.MountPath,
+			SubPath:   f.Key,
+		})
+	}
+	return container
+}
+
+// secretVolumes returns one Secret volume per distinct Secret mounted by the containers.
+func secretVolumes(containers []Container) []corev1.Volume {
+	seen := make(map[string]bool)
+	var volumes []corev1.Volume
+	for _, c := range containers {
+		for _, f := range c.SecretFiles {
+			if seen[f.Secret] {
+				continue
+			}
+			seen[f.Secret] = true
+			volumes = append(volumes, corev1.Volume{
+				Name: volumeName(f.Secret),
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{SecretName: f.Secret},
+				},
+			})
+		}
+	}
+	return volumes
+}
@@ -0,0 +1,145 @@
+package appspec
+
+import (
+	"fmt"
+	"maps"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ServiceSpec describes the Service exposing the app. Annotations are passed
+// through as-is, e.g. for MetalLB or kube-vip address pools.
+type ServiceSpec struct {
+	Name                          string                                  `json:"name,omitempty"`
+	Type                          corev1.ServiceType                      `json:"type,omitempty"`
+	Headless                      bool                                    `json:"headless,omitempty"`
+	Ports                         []corev1.ServicePort                    `json:"ports,omitempty"`
+	ExternalTrafficPolicy         corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+	LoadBalancerIP                string                                  `json:"loadBalancerIP,omitempty"`
+	LoadBalancerClass             *string                                 `json:"loadBalancerClass,omitempty"`
+	AllocateLoadBalancerNodePorts *bool                                   `json:"allocateLoadBalancerNodePorts,omitempty"`
+	Labels                        map[string]string                       `json:"labels,omitempty"`
+	Annotations                   map[string]string                       `json:"annotations,omitempty"`
+}
+
+func (s *ServiceSpec) validate() error {
+	switch s.Type {
+	case "", corev1.ServiceTypeClusterIP, corev1.ServiceTypeNodePort, corev1.ServiceTypeLoadBalancer:
+	default:
+		return fmt.Errorf("type must be ClusterIP, NodePort or LoadBalancer, got %q", s.Type)
+	}
+	exposed := s.Type == corev1.ServiceTypeNodePort || s.Type == corev1.ServiceTypeLoadBalancer
+	if s.Headless && exposed {
+		return fmt.Errorf("headless services must be of type ClusterIP")
+	}
+	if s.ExternalTrafficPolicy != "" && !exposed {
+		return fmt.Errorf("externalTrafficPolicy requires type NodePort or LoadBalancer")
+	}
+	if s.Type != corev1.ServiceTypeLoadBalancer && (s.LoadBalancerIP != "" || s.LoadBalancerClass != nil || s.AllocateLoadBalancerNodePorts != nil) {
+		return fmt.Errorf("loadBalancerIP, loadBalancerClass and allocateLoadBalancerNodePorts require type LoadBalancer")
+	}
+
+	names := make(map[string]bool)
+	for i, p := range s.Ports {
+		if p.Port == 0 {
+			return fmt.Errorf("ports[%d]: port is required", i)
+		}
+		if p.NodePort != 0 && !exposed {
+			return fmt.Errorf("ports[%d]: nodePort requires type NodePort or LoadBalancer", i)
+		}
+		if len(s.Ports) > 1 && p.Name == "" {
+			return fmt.Errorf("ports[%d]: name is required when a service has multiple ports", i)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("ports[%d]: duplicate port name %q", i, p.Name)
+		}
+		names[p.Name] = true
+	}
+	return nil
+}
+
+// ServiceName returns the Service name, defaulting to <app>-svc.
+func (s *AppSpec) ServiceName() string {
+	if s.Service != nil && s.Service.Name != "" {
+		return s.Service.Name
+	}
+	return s.Name + "-svc"
+}
+
+// servicePorts returns the configured ports, or one port per container port
+// exposed on the same number when none are configured.
+func (s *AppSpec) servicePorts() []corev1.ServicePort {
+	if len(s.Service.Ports) > 0 {
+		ports := make([]corev1.ServicePort, len(s.Service.Ports))
+		for i, p := range s.Service.Ports {
+			if p.Protocol == "" {
+				p.Protocol = corev1.ProtocolTCP
+			}
+			if p.TargetPort.Type == intstr.Int && p.TargetPort.IntVal == 0 {
+				p.TargetPort = intstr.FromInt32(p.Port)
+			}
+			ports[i] = p
+		}
+		return ports
+	}
+
+	var ports []corev1.ServicePort
+	for _, c := range s.Containers {
+		for _, p := range c.Ports {
+			name := p.Name
+			if name == "" && len(ports) == 0 {
+				name = "http"
+			}
+			protocol := p.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			ports = append(ports, corev1.ServicePort{
+				Name:       name,
+				Port:       p.ContainerPort,
+				TargetPort: intstr.FromInt32(p.ContainerPort),
+				Protocol:   protocol,
+			})
+		}
+	}
+	return ports
+}
+
+// RenderService renders the Service for the spec, or nil when it has none.
+func (s *AppSpec) RenderService(namespace string) *corev1.Service {
+	if s.Service == nil {
+		return nil
+	}
+
+	labels := s.podLabels()
+	maps.Copy(labels, s.Service.Labels)
+	serviceType := s.Service.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+
+	service := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        s.ServiceName(),
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: s.Service.Annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                          serviceType,
+			Selector:                      s.SelectorLabels(),
+			Ports:                         s.servicePorts(),
+			ExternalTrafficPolicy:         s.Service.ExternalTrafficPolicy,
+			LoadBalancerIP:                s.Service.LoadBalancerIP,
+			LoadBalancerClass:             s.Service.LoadBalancerClass,
+			AllocateLoadBalancerNodePorts: s.Service.AllocateLoadBalancerNodePorts,
+		},
+	}
+	if s.Service.Headless {
+		service.Spec.ClusterIP = corev1.ClusterIPNone
+	}
+	return service
+}
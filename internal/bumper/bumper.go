@@ -3,56 +3,122 @@ package bumper
 import (
 	"fmt"
 	"strconv"
-	"strings"
 )
 
-// BumpVersion takes a semantic version string (e.g., "v1.0.13") and an increment
-// type ("major", "minor", or "patch"). It returns the new version string after bumping
-// the specified part. If the increment type is unrecognized or omitted, it defaults to "patch".
-func BumpVersion(currentVersion, increment string) (string, error) {
-	// Remove a leading "v" if present.
-	version := currentVersion
-	version = strings.TrimPrefix(version, "v")
+// Increment names the part of a version that is bumped.
+type Increment string
+
+const (
+	Major Increment = "major"
+	Minor Increment = "minor"
+	Patch Increment = "patch"
+	// Prerelease bumps the last number of a pre-release, e.g. v1.3.0-rc.1 to
+	// v1.3.0-rc.2. A release starts the pre-releases of its next patch, e.g.
+	// v1.2.2 to v1.2.3-rc.1.
+	Prerelease Increment = "prerelease"
+	// Release drops the pre-release, e.g. v1.3.0-rc.2 to v1.3.0.
+	Release Increment = "release"
+)
 
-	// Split the version string by "."
-	parts := strings.Split(version, ".")
-	if len(parts) != 3 {
-		return "", fmt.Errorf("version string does not match expected format (e.g., v1.0.13)")
+// DefaultPreid is the identifier new pre-releases start with.
+const DefaultPreid = "rc"
+
+func ParseIncrement(s string) (Increment, error) {
+	switch increment := Increment(s); increment {
+	case Major, Minor, Patch, Prerelease, Release:
+		return increment, nil
 	}
+	return "", fmt.Errorf("unknown increment type %q, must be major, minor, patch, prerelease or release", s)
+}
 
-	// Convert parts to integers.
-	major, err := strconv.Atoi(parts[0])
-	if err != nil {
-		return "", fmt.Errorf("invalid major version: %v", err)
+// Bump returns the version following v. New pre-releases are named preid, or
+// DefaultPreid when preid is empty; bumping a pre-release to a different preid
+// restarts its number, e.g. v1.3.0-beta.2 to v1.3.0-rc.1. Build metadata is
+// dropped, see WithBuild. Bumps that would not increase the precedence of v,
+// such as releasing a release, are errors.
+func (v Version) Bump(increment Increment, preid string) (Version, error) {
+	next := v
+	next.Prerelease, next.Build = nil, nil
+	switch increment {
+	case Major:
+		next.Major, next.Minor, next.Patch = v.Major+1, 0, 0
+	case Minor:
+		next.Minor, next.Patch = v.Minor+1, 0
+	case Patch:
+		next.Patch++
+	case Prerelease:
+		if preid == "" {
+			preid = DefaultPreid
+		}
+		if _, err := parseIdentifiers(preid, true); err != nil {
+			return Version{}, fmt.Errorf("invalid pre-release identifier %q %w", preid, err)
+		}
+		switch {
+		case !v.IsPrerelease():
+			next.Patch++
+			next.Prerelease = []string{preid, "1"}
+		case v.Prerelease[0] == preid:
+			next.Prerelease = bumpPrerelease(v.Prerelease)
+		default:
+			next.Prerelease = []string{preid, "1"}
+		}
+	case Release:
+		if !v.IsPrerelease() {
+			return Version{}, fmt.Errorf("%s is not a pre-release", v)
+		}
+	default:
+		_, err := ParseIncrement(string(increment))
+		return Version{}, err
 	}
 
-	minor, err := strconv.Atoi(parts[1])
-	if err != nil {
-		return "", fmt.Errorf("invalid minor version: %v", err)
+	if next.Compare(v) <= 0 {
+		return Version{}, fmt.Errorf("%s bump of %s to %s does not increase the version", increment, v, next)
 	}
+	return next, nil
+}
 
-	patch, err := strconv.Atoi(parts[2])
-	if err != nil {
-		return "", fmt.Errorf("invalid patch version: %v", err)
+// bumpPrerelease increments the last identifier when it is numeric and
+// appends ".1" otherwise, e.g. rc.1 to rc.2 and rc to rc.1.
+func bumpPrerelease(ids []string) []string {
+	next := append([]string(nil), ids...)
+	last := len(next) - 1
+	if n, err := strconv.ParseUint(next[last], 10, 64); err == nil {
+		next[last] = strconv.FormatUint(n+1, 10)
+		return next
 	}
+	return append(next, "1")
+}
 
-	// Increment the chosen field.
-	switch increment {
-	case "major":
-		major++
-		minor = 0
-		patch = 0
-	case "minor":
-		minor++
-		patch = 0
-	case "patch":
-		fallthrough
-	default:
-		patch++
+// WithBuild returns v with build metadata such as "sha.5114f85". An empty
+// build removes it.
+func (v Version) WithBuild(build string) (Version, error) {
+	if build == "" {
+		v.Build = nil
+		return v, nil
+	}
+	ids, err := parseIdentifiers(build, false)
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid build metadata %q %w", build, err)
 	}
+	v.Build = ids
+	return v, nil
+}
 
-	// Return the new version with a "v" prefix.
-	newTag := fmt.Sprintf("v%d.%d.%d", major, minor, patch)
-	fmt.Println(newTag)
-	return newTag, err
+// BumpVersion takes a semantic version string (e.g., "v1.0.13" or "v1.3.0-rc.1")
+// and an increment type (see Increment). It returns the new version string,
+// starting new pre-releases at DefaultPreid.
+func BumpVersion(currentVersion, increment string) (string, error) {
+	version, err := Parse(currentVersion)
+	if err != nil {
+		return "", err
+	}
+	inc, err := ParseIncrement(increment)
+	if err != nil {
+		return "", err
+	}
+	next, err := version.Bump(inc, DefaultPreid)
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
 }
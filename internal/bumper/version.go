@@ -0,0 +1,163 @@
+package bumper
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version as specified by https://semver.org/spec/v2.0.0.html,
+// optionally written with a leading "v" as in git tags and image tags.
+type Version struct {
+	// Prefix is "v" when the version was written with one, and is kept by String.
+	Prefix string
+	Major  uint64
+	Minor  uint64
+	Patch  uint64
+	// Prerelease holds the dot separated identifiers after "-", e.g. ["rc", "1"].
+	Prerelease []string
+	// Build holds the dot separated identifiers after "+". It is ignored when
+	// comparing versions.
+	Build []string
+}
+
+// Parse parses a version such as "1.2.3", "v1.3.0-rc.1" or "v1.3.0+sha.5114f85".
+func Parse(s string) (Version, error) {
+	var v Version
+	rest := s
+	if strings.HasPrefix(rest, "v") {
+		v.Prefix, rest = "v", rest[1:]
+	}
+	rest, build, hasBuild := strings.Cut(rest, "+")
+	core, prerelease, hasPrerelease := strings.Cut(rest, "-")
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("version %q does not match the expected format MAJOR.MINOR.PATCH, e.g. v1.0.13", s)
+	}
+	for i, field := range []*uint64{&v.Major, &v.Minor, &v.Patch} {
+		n, err := parseNumber(parts[i])
+		if err != nil {
+			return Version{}, fmt.Errorf("invalid %s version in %q %w", []string{"major", "minor", "patch"}[i], s, err)
+		}
+		*field = n
+	}
+
+	var err error
+	if hasPrerelease {
+		if v.Prerelease, err = parseIdentifiers(prerelease, true); err != nil {
+			return Version{}, fmt.Errorf("invalid pre-release in %q %w", s, err)
+		}
+	}
+	if hasBuild {
+		if v.Build, err = parseIdentifiers(build, false); err != nil {
+			return Version{}, fmt.Errorf("invalid build metadata in %q %w", s, err)
+		}
+	}
+	return v, nil
+}
+
+// parseNumber parses a numeric identifier, which must not have leading zeros.
+func parseNumber(s string) (uint64, error) {
+	if len(s) > 1 && s[0] == '0' {
+		return 0, fmt.Errorf("%q has a leading zero", s)
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// parseIdentifiers splits s into dot separated identifiers of ASCII
+// alphanumerics and hyphens. Numeric pre-release identifiers must not have
+// leading zeros.
+func parseIdentifiers(s string, prerelease bool) ([]string, error) {
+	ids := strings.Split(s, ".")
+	for _, id := range ids {
+		if id == "" {
+			return nil, fmt.Errorf("empty identifier")
+		}
+		for _, r := range id {
+			if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-') {
+				return nil, fmt.Errorf("identifier %q contains %q", id, r)
+			}
+		}
+		if prerelease && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return nil, fmt.Errorf("identifier %q has a leading zero", id)
+		}
+	}
+	return ids, nil
+}
+
+func isNumeric(id string) bool {
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return id != ""
+}
+
+// String formats v, e.g. "v1.3.0-rc.1+sha.5114f85".
+func (v Version) String() string {
+	s := fmt.Sprintf("%s%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) > 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) > 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// IsPrerelease reports whether v has pre-release identifiers.
+func (v Version) IsPrerelease() bool {
+	return len(v.Prerelease) > 0
+}
+
+// Compare returns -1, 0 or +1 as v has lower, equal or higher precedence than
+// w. The prefix and build metadata do not affect precedence.
+func (v Version) Compare(w Version) int {
+	for _, c := range [][2]uint64{{v.Major, w.Major}, {v.Minor, w.Minor}, {v.Patch, w.Patch}} {
+		if c[0] != c[1] {
+			return cmp.Compare(c[0], c[1])
+		}
+	}
+
+	// A pre-release has lower precedence than the release itself.
+	switch {
+	case len(v.Prerelease) == 0 && len(w.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(w.Prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Prerelease) && i < len(w.Prerelease); i++ {
+		if c := compareIdentifiers(v.Prerelease[i], w.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(v.Prerelease), len(w.Prerelease))
+}
+
+// compareIdentifiers compares numeric identifiers numerically and others in
+// ASCII order. Numeric identifiers have lower precedence than others.
+func compareIdentifiers(a string, b string) int {
+	aNumeric, bNumeric := isNumeric(a), isNumeric(b)
+	switch {
+	case aNumeric && bNumeric:
+		if len(a) != len(b) {
+			return cmp.Compare(len(a), len(b))
+		}
+		return strings.Compare(a, b)
+	case aNumeric:
+		return -1
+	case bNumeric:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Compare compares a and b as Version.Compare does, for use with slices.SortFunc.
+func Compare(a Version, b Version) int {
+	return a.Compare(b)
+}
@@ -0,0 +1,130 @@
+package bumper
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "v1.0.13", want: "v1.0.13"},
+		{in: "1.2.3", want: "1.2.3"},
+		{in: "v1.3.0-rc.1", want: "v1.3.0-rc.1"},
+		{in: "v1.3.0-alpha-2.x.7+sha.5114f85", want: "v1.3.0-alpha-2.x.7+sha.5114f85"},
+		{in: "1.0.0+001", want: "1.0.0+001"},
+		{in: "v1.2", wantErr: true},
+		{in: "v1.2.3.4", wantErr: true},
+		{in: "v01.2.3", wantErr: true},
+		{in: "v1.2.x", wantErr: true},
+		{in: "v1.2.3-", wantErr: true},
+		{in: "v1.2.3-rc..1", wantErr: true},
+		{in: "v1.2.3-rc.01", wantErr: true},
+		{in: "v1.2.3-rc_1", wantErr: true},
+		{in: "v1.2.3+", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			v, err := Parse(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if err == nil && v.String() != tt.want {
+				t.Errorf("Parse().String() = %q, want %q", v.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	// Ordered by precedence as in https://semver.org/spec/v2.0.0.html#spec-item-11.
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "1.0.1", "1.1.0", "v2.0.0",
+	}
+	var versions []Version
+	for _, s := range ordered {
+		v, err := Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	shuffled := slices.Clone(versions)
+	slices.Reverse(shuffled)
+	slices.SortFunc(shuffled, Compare)
+	for i := range versions {
+		if shuffled[i].String() != versions[i].String() {
+			t.Fatalf("sorted = %v, want %v", shuffled, versions)
+		}
+	}
+
+	a, _ := Parse("v1.0.0+build.1")
+	b, _ := Parse("1.0.0+build.2")
+	if c := a.Compare(b); c != 0 {
+		t.Errorf("Compare() ignoring prefix and build = %d, want 0", c)
+	}
+}
+
+func TestBumpVersion(t *testing.T) {
+	tests := []struct {
+		version   string
+		increment string
+		want      string
+		wantErr   bool
+	}{
+		{version: "v1.0.13", increment: "patch", want: "v1.0.14"},
+		{version: "v1.0.13", increment: "minor", want: "v1.1.0"},
+		{version: "v1.0.13", increment: "major", want: "v2.0.0"},
+		{version: "v1.3.0-rc.1", increment: "patch", want: "v1.3.1"},
+		{version: "v1.3.0-rc.1+sha.5114f85", increment: "prerelease", want: "v1.3.0-rc.2"},
+		{version: "v1.3.0-rc", increment: "prerelease", want: "v1.3.0-rc.1"},
+		{version: "v1.3.0-rc.9", increment: "prerelease", want: "v1.3.0-rc.10"},
+		{version: "v1.3.0-beta.2", increment: "prerelease", want: "v1.3.0-rc.1"},
+		{version: "v1.2.2", increment: "prerelease", want: "v1.2.3-rc.1"},
+		{version: "v1.3.0-rc.2", increment: "release", want: "v1.3.0"},
+		{version: "v1.3.0", increment: "release", wantErr: true},
+		{version: "v1.3.0-rc.2", increment: "", wantErr: true},
+		{version: "v1.3.0", increment: "hotfix", wantErr: true},
+		{version: "1.3", increment: "patch", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version+"/"+tt.increment, func(t *testing.T) {
+			got, err := BumpVersion(tt.version, tt.increment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BumpVersion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("BumpVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBumpPreid(t *testing.T) {
+	v, _ := Parse("v1.3.0-rc.2")
+	if _, err := v.Bump(Prerelease, "beta"); err == nil {
+		t.Error("Bump() from rc to beta succeeded, want an error as beta precedes rc")
+	}
+	if _, err := v.Bump(Prerelease, "rc_2"); err == nil {
+		t.Error("Bump() with an invalid preid succeeded")
+	}
+
+	next, err := v.Bump(Release, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err = next.WithBuild("sha.5114f85")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := next.String(); got != "v1.3.0+sha.5114f85" {
+		t.Errorf("String() = %q, want v1.3.0+sha.5114f85", got)
+	}
+	if _, err := next.WithBuild("sha..1"); err == nil {
+		t.Error("WithBuild() with an empty identifier succeeded")
+	}
+}
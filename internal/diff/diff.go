@@ -0,0 +1,171 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the number of unchanged lines shown around each change.
+const DefaultContext = 3
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	line string
+}
+
+// lineOps computes a line based edit script from a to b using the longest
+// common subsequence. Manifests are small enough for the O(n*m) table.
+func lineOps(a, b []string) []op {
+	n, m := len(a), len(b)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]op, 0, n+m)
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{opEqual, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, op{opDelete, a[i]})
+			i++
+		default:
+			ops = append(ops, op{opInsert, b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, op{opDelete, a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, op{opInsert, b[j]})
+	}
+	return ops
+}
+
+// splitLines splits s after each newline, so a last line without one differs
+// from the same line with one.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writeLine writes line with its prefix, marking a missing final newline the
+// way diff(1) does.
+func writeLine(b *strings.Builder, prefix string, line string) {
+	b.WriteString(prefix + line)
+	if !strings.HasSuffix(line, "\n") {
+		b.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// Unified returns a unified diff between a and b with the given number of
+// context lines, or an empty string when they are equal.
+func Unified(fromName, toName, a, b string, context int) string {
+	ops := lineOps(splitLines(a), splitLines(b))
+
+	changed := false
+	for _, o := range ops {
+		if o.kind != opEqual {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Walk the edit script, emitting a hunk for each run of changes merged with
+	// any neighbouring run closer than 2*context unchanged lines.
+	aLine, bLine := 1, 1
+	for start := 0; start < len(ops); {
+		if ops[start].kind == opEqual {
+			aLine++
+			bLine++
+			start++
+			continue
+		}
+
+		hunkStart := max(start-context, 0)
+		end := start
+		for end < len(ops) {
+			if ops[end].kind != opEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == opEqual {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(end+context, len(ops))
+				break
+			}
+			end = run
+		}
+
+		leading := start - hunkStart
+		aStart, bStart := aLine-leading, bLine-leading
+		aCount, bCount := 0, 0
+		var body strings.Builder
+		for _, o := range ops[hunkStart:end] {
+			switch o.kind {
+			case opEqual:
+				writeLine(&body, " ", o.line)
+				aCount++
+				bCount++
+			case opDelete:
+				writeLine(&body, "-", o.line)
+				aCount++
+			case opInsert:
+				writeLine(&body, "+", o.line)
+				bCount++
+			}
+		}
+		aHeader, bHeader := aStart, bStart
+		// An empty range is reported as starting on the line before it.
+		if aCount == 0 {
+			aHeader--
+		}
+		if bCount == 0 {
+			bHeader--
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aHeader, aCount, bHeader, bCount)
+		out.WriteString(body.String())
+
+		aLine = aStart + aCount
+		bLine = bStart + bCount
+		start = end
+	}
+	return out.String()
+}
@@ -0,0 +1,105 @@
+package diff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a, b    string
+		context int
+		want    string
+	}{
+		{
+			name: "equal",
+			a:    "a\nb\n",
+			b:    "a\nb\n",
+			want: "",
+		},
+		{
+			name: "both empty",
+			want: "",
+		},
+		{
+			name: "create",
+			b:    "a\nb\n",
+			want: "--- live\n+++ desired\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "delete everything",
+			a:    "a\nb\n",
+			want: "--- live\n+++ desired\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name:    "insertion",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:       "1\n2\n3\n4\nnew\n5\n6\n7\n8\n",
+			context: 3,
+			want:    "--- live\n+++ desired\n@@ -2,6 +2,7 @@\n 2\n 3\n 4\n+new\n 5\n 6\n 7\n",
+		},
+		{
+			name:    "insertion without context",
+			a:       "1\n2\n3\n",
+			b:       "1\n2\nnew\n3\n",
+			context: 0,
+			want:    "--- live\n+++ desired\n@@ -2,0 +3,1 @@\n+new\n",
+		},
+		{
+			name:    "deletion",
+			a:       "1\n2\n3\n4\n5\n",
+			b:       "1\n2\n4\n5\n",
+			context: 1,
+			want:    "--- live\n+++ desired\n@@ -2,3 +2,2 @@\n 2\n-3\n 4\n",
+		},
+		{
+			name:    "deletion without context",
+			a:       "1\n2\n3\n",
+			b:       "1\n3\n",
+			context: 0,
+			want:    "--- live\n+++ desired\n@@ -2,1 +1,0 @@\n-2\n",
+		},
+		{
+			name:    "changes within twice the context merge",
+			a:       "1\n2\n3\n4\n5\n6\n7\n",
+			b:       "1\nx\n3\n4\n5\ny\n7\n",
+			context: 2,
+			want:    "--- live\n+++ desired\n@@ -1,7 +1,7 @@\n 1\n-2\n+x\n 3\n 4\n 5\n-6\n+y\n 7\n",
+		},
+		{
+			name:    "changes further apart get separate hunks",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			b:       "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			context: 2,
+			want: "--- live\n+++ desired\n@@ -1,3 +1,3 @@\n-1\n+x\n 2\n 3\n" +
+				"@@ -8,3 +8,3 @@\n 8\n 9\n-10\n+y\n",
+		},
+		{
+			name:    "hunk line numbers after an earlier insertion",
+			a:       "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:       "0\n1\n2\n3\n4\n5\n6\n7\nx\n",
+			context: 1,
+			want: "--- live\n+++ desired\n@@ -1,1 +1,2 @@\n+0\n 1\n" +
+				"@@ -7,2 +8,2 @@\n 7\n-8\n+x\n",
+		},
+		{
+			name:    "missing trailing newline",
+			a:       "a\nb\n",
+			b:       "a\nb",
+			context: 3,
+			want:    "--- live\n+++ desired\n@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "unchanged last line without newline",
+			a:       "a\nb",
+			b:       "x\nb",
+			context: 1,
+			want:    "--- live\n+++ desired\n@@ -1,2 +1,2 @@\n-a\n+x\n b\n\\ No newline at end of file\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("live", "desired", tt.a, tt.b, tt.context); got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package pretty
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Handler is a slog.Handler that prints records through a Printer, so log
+// records and status lines share one writer, level and theme. A record is
+// printed as its message followed by its attributes as key=value.
+type Handler struct {
+	printer *Printer
+	// prefix is the group path prepended to attribute keys, e.g. "request.".
+	prefix string
+	// attrs are the attributes added with WithAttrs, already formatted.
+	attrs string
+}
+
+// NewHandler returns a Handler printing through p.
+func NewHandler(p *Printer) *Handler {
+	return &Handler{printer: p}
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.printer.Enabled(level)
+}
+
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.prefix, a)
+		return true
+	})
+	h.printer.Log(r.Level, b.String())
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.prefix, a)
+	}
+	handler := *h
+	handler.attrs += b.String()
+	return &handler
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	handler := *h
+	handler.prefix += name + "."
+	return &handler
+}
+
+// appendAttr writes a as " key=value", flattening groups into dotted keys and
+// quoting values that are empty or contain spaces, quotes or '='.
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, attr := range a.Value.Group() {
+			appendAttr(b, prefix, attr)
+		}
+		return
+	}
+
+	value := a.Value.String()
+	if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, value)
+}
@@ -1,224 +1,235 @@
+// Package pretty prints leveled, optionally colored status lines for humans.
+// A Printer writes to a single io.Writer; colors are used only on terminals
+// when NO_COLOR is not set. The package-level functions use the default
+// Printer, and Handler lets slog records share the same output.
 package pretty
 
 import (
-	"encoding/json"
 	"fmt"
-	"time"
-
-	batchv1 "k8s.io/api/batch/v1"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
 )
 
-type PrettyPrintOption func(p *prettyPrinter)
-
-type PrettyPrinter interface {
-	Print(s string)
-	Printf(format string, a ...any)
-	PrintWarning(s string)
-	PrintWarningf(format string, a ...any)
-	PrintError(s string)
-	PrintErrorf(format string, a ...any)
-	PrettyPrintDateTime(time.Time)
-	PrettyPrintTime(time.Time)
-	PrettyPrintDate(time.Time)
-	DateTimeSting(time.Time) string
+// Printer writes lines at or above its level to a writer, styled by its theme.
+// It is safe for concurrent use; an active Progress line is cleared before
+// other output and redrawn after it.
+type Printer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	level    slog.Level
+	theme    Theme
+	color    bool
+	terminal bool
+	progress *Progress
+}
+
+type Option func(p *Printer)
+
+// WithLevel drops lines below level. The default is slog.LevelInfo.
+func WithLevel(level slog.Level) Option {
+	return func(p *Printer) {
+		p.level = level
+	}
 }
 
-type prettyPrinter struct {
-	InfoColor int32 `json:"infoColor"`
-	WarnColor int32 `json:"warnColor"`
-	ErrColor  int32 `json:"errorColor"`
+// WithTheme sets the styles used for each level and for diffs.
+func WithTheme(theme Theme) Option {
+	return func(p *Printer) {
+		p.theme = theme
+	}
 }
 
-func WithInfoColor(c int32) PrettyPrintOption {
-	return func(p *prettyPrinter) {
-		p.InfoColor = c
+// WithColor forces colors on or off instead of detecting them.
+func WithColor(color bool) Option {
+	return func(p *Printer) {
+		p.color = color
 	}
 }
 
-func WithWarnColor(c int32) PrettyPrintOption {
-	return func(p *prettyPrinter) {
-		p.WarnColor = c
+// New returns a Printer writing to w. Colors and in-place progress lines are
+// enabled when w is a terminal; NO_COLOR (https://no-color.org) disables colors.
+func New(w io.Writer, opts ...Option) *Printer {
+	terminal := IsTerminal(w)
+	p := &Printer{
+		w:        w,
+		level:    slog.LevelInfo,
+		theme:    DefaultTheme(),
+		color:    terminal && os.Getenv("NO_COLOR") == "",
+		terminal: terminal,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
-func WithErrColor(c int32) PrettyPrintOption {
-	return func(p *prettyPrinter) {
-		p.ErrColor = c
+// IsTerminal reports whether w is a character device such as a terminal.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
 	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-func NewPrettyPrinter(opts ...PrettyPrintOption) *prettyPrinter {
-	const (
-		infoColor = int32(92)
-		warnColor = int32(93)
-		errColor  = int32(91)
-	)
-
-	printer := &prettyPrinter{
-		InfoColor: infoColor,
-		WarnColor: warnColor,
-		ErrColor:  errColor,
-	}
-	for _, opt := range opts {
-		opt(printer)
-	}
-	return printer
+// Writer returns the writer the Printer writes to, for output that is not
+// line oriented such as rendered manifests.
+func (p *Printer) Writer() io.Writer {
+	return p.w
 }
 
-func (p *prettyPrinter) Print(s ...any) {
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", p.InfoColor, s)
+// Enabled reports whether lines at level are printed.
+func (p *Printer) Enabled(level slog.Level) bool {
+	return level >= p.level
 }
 
-func (p *prettyPrinter) Printf(format string, a ...any) {
-	fstring := fmt.Sprintf(format, a...)
-	p.Print(fstring)
+// Log prints msg at level. Multi-line messages are styled line by line.
+func (p *Printer) Log(level slog.Level, msg string) {
+	if !p.Enabled(level) {
+		return
+	}
+	p.write(p.theme.level(level), msg)
 }
 
-func (p *prettyPrinter) PrintWarning(s ...any) {
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", p.WarnColor, s)
+func (p *Printer) Debugf(format string, a ...any) {
+	p.Log(slog.LevelDebug, fmt.Sprintf(format, a...))
 }
 
-func (p *prettyPrinter) PrintWarningf(format string, a ...any) {
-	fstring := fmt.Sprintf(format, a...)
-	p.PrintWarning(fstring)
+func (p *Printer) Print(a ...any) {
+	p.Log(slog.LevelInfo, fmt.Sprint(a...))
 }
 
-func (p *prettyPrinter) PrintError(s ...any) {
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", p.ErrColor, s)
+func (p *Printer) Printf(format string, a ...any) {
+	p.Log(slog.LevelInfo, fmt.Sprintf(format, a...))
 }
 
-func (p *prettyPrinter) PrintErrorf(format string, a ...any) {
-	fstring := fmt.Sprintf(format, a...)
-	p.PrintError(fstring)
+func (p *Printer) PrintWarning(a ...any) {
+	p.Log(slog.LevelWarn, fmt.Sprint(a...))
 }
 
-func (p *prettyPrinter) PrettyPrintDateTime(t time.Time) {
-	dateTimeString := fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02d",
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second())
-	p.Print(dateTimeString)
+func (p *Printer) PrintWarningf(format string, a ...any) {
+	p.Log(slog.LevelWarn, fmt.Sprintf(format, a...))
 }
 
-func (p *prettyPrinter) PrettyPrintDate(t time.Time) {
-	dateTimeString := fmt.Sprintf("%d-%02d-%02d",
-		t.Year(), t.Month(), t.Day())
-	p.Print(dateTimeString)
+func (p *Printer) PrintError(a ...any) {
+	p.Log(slog.LevelError, fmt.Sprint(a...))
 }
 
-func (p *prettyPrinter) PrettyPrintTime(t time.Time) {
-	dateTimeString := fmt.Sprintf("%02d:%02d:%02d",
-		t.Hour(), t.Minute(), t.Second())
-	p.Print(dateTimeString)
+func (p *Printer) PrintErrorf(format string, a ...any) {
+	p.Log(slog.LevelError, fmt.Sprintf(format, a...))
 }
 
-func (p *prettyPrinter) DateTimeSting(t time.Time) string {
-	dateTimeString := fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02d",
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second())
-	return dateTimeString
+// PrintDiff prints a unified diff, styling file headers, added and removed
+// lines and hunk headers. Diffs are printed at every level.
+func (p *Printer) PrintDiff(diff string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clearProgress()
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		style := Style("")
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			style = p.theme.DiffHeader
+		case strings.HasPrefix(line, "+"):
+			style = p.theme.DiffAdd
+		case strings.HasPrefix(line, "-"):
+			style = p.theme.DiffDelete
+		case strings.HasPrefix(line, "@@"):
+			style = p.theme.DiffHunk
+		}
+		fmt.Fprintln(p.w, p.style(style, line))
+	}
+	p.drawProgress()
 }
 
-func (p *prettyPrinter) PrettyPrintJson(data []byte) {
-	Print("####### Json Data #######")
-	response, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		p.PrintErrorf("Error marshaling response: %s", err.Error())
+// ClearScreen clears a terminal and moves the cursor to the top left, for
+// output that is redrawn as a whole. It does nothing on other writers.
+func (p *Printer) ClearScreen() {
+	if !p.terminal {
+		return
 	}
-	p.Print(string(response))
-	fmt.Println()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprint(p.w, "\x1b[H\x1b[2J")
+	p.drawProgress()
+}
+
+// write prints each line of msg in style, keeping an active progress line last.
+func (p *Printer) write(style Style, msg string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clearProgress()
+	for _, line := range strings.Split(msg, "\n") {
+		fmt.Fprintln(p.w, p.style(style, line))
+	}
+	p.drawProgress()
 }
 
-func Print(s ...any) {
-	const (
-		infoColor = int32(92)
-	)
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", infoColor, s)
+// style wraps s in the SGR escape sequence of style when colors are enabled.
+func (p *Printer) style(style Style, s string) string {
+	if !p.color || style == "" {
+		return s
+	}
+	return fmt.Sprintf("\x1b[%sm%s\x1b[0m", style, s)
 }
 
-func Printf(format string, a ...any) {
-	const (
-		infoColor = int32(92)
-	)
-	fstring := fmt.Sprintf(format, a...)
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", infoColor, fstring)
+var (
+	defaultMu      sync.RWMutex
+	defaultPrinter = New(os.Stdout)
+)
+
+// Default returns the Printer used by the package-level functions, which
+// writes to stdout until replaced with SetDefault.
+func Default() *Printer {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultPrinter
 }
 
-func PrintWarning(s ...any) {
-	const (
-		warnColor = int32(93)
-	)
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", warnColor, s)
+// SetDefault makes p the Printer used by the package-level functions.
+func SetDefault(p *Printer) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultPrinter = p
 }
 
-func PrintWarningf(format string, a ...any) {
-	const (
-		warnColor = int32(93)
-	)
-	fstring := fmt.Sprintf(format, a...)
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", warnColor, fstring)
+func Debugf(format string, a ...any) {
+	Default().Debugf(format, a...)
 }
 
-func PrintError(s ...any) {
-	const (
-		errColor = int32(91)
-	)
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", errColor, s)
+func Print(a ...any) {
+	Default().Print(a...)
 }
 
-func PrintErrorf(format string, a ...any) {
-	const (
-		errColor = int32(91)
-	)
-	fstring := fmt.Sprintf(format, a...)
-	fmt.Printf("\x1b[1;%dm%s\x1b[0m\n", errColor, fstring)
+func Printf(format string, a ...any) {
+	Default().Printf(format, a...)
 }
 
-func PrettyPrintDateTime(t time.Time) {
-	dateTimeString := fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02d",
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second())
-	Print(dateTimeString)
+func PrintWarning(a ...any) {
+	Default().PrintWarning(a...)
 }
 
-func PrettyPrintDate(t time.Time) {
-	dateTimeString := fmt.Sprintf("%d-%02d-%02d",
-		t.Year(), t.Month(), t.Day())
-	Print(dateTimeString)
+func PrintWarningf(format string, a ...any) {
+	Default().PrintWarningf(format, a...)
 }
 
-func PrettyPrintTime(t time.Time) {
-	dateTimeString := fmt.Sprintf("%02d:%02d:%02d",
-		t.Hour(), t.Minute(), t.Second())
-	Print(dateTimeString)
+func PrintError(a ...any) {
+	Default().PrintError(a...)
 }
 
-func DateTimeSting(t time.Time) string {
-	dateTimeString := fmt.Sprintf("%d-%02d-%02dT%02d:%02d:%02d",
-		t.Year(), t.Month(), t.Day(),
-		t.Hour(), t.Minute(), t.Second())
-	return dateTimeString
+func PrintErrorf(format string, a ...any) {
+	Default().PrintErrorf(format, a...)
 }
 
-func PrettyPrintK8sJob(jobsList *batchv1.JobList) {
-	// Debug output of job statuses
-	for _, j := range jobsList.Items {
-		fmt.Println()
-		response, err := json.MarshalIndent(j.Status, "", "  ")
-		if err != nil {
-			PrintErrorf("Error marshaling response: %s", err.Error())
-			continue
-		}
-		Print(string(response))
-		fmt.Println()
-	}
+func PrintDiff(diff string) {
+	Default().PrintDiff(diff)
 }
 
-func PrettyPrintJson(data []byte) {
-	Print("####### Json Data #######")
-	response, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		PrintErrorf("Error marshaling response: %s", err.Error())
-	}
-	Print(string(response))
-	fmt.Println()
+// StartProgress starts a progress line on the default Printer.
+func StartProgress(msg string) *Progress {
+	return Default().StartProgress(msg)
 }
@@ -0,0 +1,95 @@
+package pretty
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestPrinterLevels(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(&buf, WithLevel(slog.LevelWarn))
+	p.Print("hidden")
+	p.Debugf("hidden %d", 1)
+	p.PrintWarningf("disk %d%% full", 90)
+	p.PrintError("failed")
+
+	if got, want := buf.String(), "disk 90% full\nfailed\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestPrinterColor(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf).Printf("plain %s", "text")
+	if got, want := buf.String(), "plain text\n"; got != want {
+		t.Errorf("non-terminal output = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	p := New(&buf, WithColor(true))
+	p.PrintError("line one\nline two")
+	if got, want := buf.String(), "\x1b[1;91mline one\x1b[0m\n\x1b[1;91mline two\x1b[0m\n"; got != want {
+		t.Errorf("colored output = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	New(&buf, WithColor(true), WithTheme(MonochromeTheme())).Print("info")
+	if got, want := buf.String(), "info\n"; got != want {
+		t.Errorf("monochrome info = %q, want %q", got, want)
+	}
+}
+
+func TestPrintDiff(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, WithColor(true), WithLevel(slog.LevelError)).PrintDiff("--- a\n+++ b\n@@ -1 +1 @@\n-old\n+new\n same\n")
+	want := "\x1b[1m--- a\x1b[0m\n\x1b[1m+++ b\x1b[0m\n\x1b[96m@@ -1 +1 @@\x1b[0m\n\x1b[91m-old\x1b[0m\n\x1b[92m+new\x1b[0m\n same\n"
+	if got := buf.String(); got != want {
+		t.Errorf("diff output = %q, want %q", got, want)
+	}
+}
+
+func TestProgressWithoutTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(&buf)
+	progress := p.StartProgress("waiting")
+	progress.Update("waiting")
+	progress.Update("1 of 2 ready")
+	p.Print("other")
+	progress.Stop()
+	progress.Stop()
+
+	if got, want := buf.String(), "waiting\n1 of 2 ready\nother\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestProgressInPlace(t *testing.T) {
+	var buf bytes.Buffer
+	p := New(&buf)
+	p.terminal = true
+	progress := p.StartProgress("waiting")
+	p.Print("other")
+	progress.Stop()
+
+	want := "\r\x1b[K⠋ waiting (0s)" + "\r\x1b[Kother\n" + "\r\x1b[K⠋ waiting (0s)" + "\r\x1b[K"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(New(&buf)))
+	logger.Debug("hidden")
+	logger.With(slog.String("job", "init-db")).WithGroup("status").Info("Job status",
+		slog.Int("active", 1),
+		slog.String("reason", "Backoff Limit"),
+		slog.Group("pod", slog.String("name", "init-db-x")),
+		slog.String("empty", ""))
+
+	want := `Job status job=init-db status.active=1 status.reason="Backoff Limit" status.pod.name=init-db-x status.empty=""` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
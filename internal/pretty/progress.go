@@ -0,0 +1,120 @@
+package pretty
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const spinnerInterval = 100 * time.Millisecond
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Progress is the status line of a long wait. On a terminal it is redrawn in
+// place with a spinner and the elapsed time, below any other output. On other
+// writers each new message is printed once as an info line.
+type Progress struct {
+	printer *Printer
+	msg     string
+	start   time.Time
+	frame   int
+	inPlace bool
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// StartProgress shows msg as the progress line until Stop is called. Starting
+// a progress line replaces the active one.
+func (p *Printer) StartProgress(msg string) *Progress {
+	progress := &Progress{
+		printer: p,
+		msg:     msg,
+		start:   time.Now(),
+		inPlace: p.terminal && p.Enabled(slog.LevelInfo),
+		done:    make(chan struct{}),
+	}
+	if !progress.inPlace {
+		p.Print(msg)
+		return progress
+	}
+
+	p.mu.Lock()
+	p.clearProgress()
+	p.progress = progress
+	p.drawProgress()
+	p.mu.Unlock()
+	go progress.spin()
+	return progress
+}
+
+// Update replaces the progress message.
+func (r *Progress) Update(msg string) {
+	p := r.printer
+	p.mu.Lock()
+	if msg == r.msg {
+		p.mu.Unlock()
+		return
+	}
+	r.msg = msg
+	if r.inPlace {
+		if p.progress == r {
+			p.drawProgress()
+		}
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	p.Print(msg)
+}
+
+// Stop removes the progress line. It is safe to call more than once.
+func (r *Progress) Stop() {
+	r.stop.Do(func() {
+		close(r.done)
+		p := r.printer
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.progress == r {
+			p.clearProgress()
+			p.progress = nil
+		}
+	})
+}
+
+func (r *Progress) spin() {
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			p := r.printer
+			p.mu.Lock()
+			r.frame++
+			if p.progress == r {
+				p.drawProgress()
+			}
+			p.mu.Unlock()
+		}
+	}
+}
+
+// drawProgress redraws the active progress line. The caller holds p.mu.
+func (p *Printer) drawProgress() {
+	r := p.progress
+	if r == nil {
+		return
+	}
+	line := fmt.Sprintf("%s %s (%s)", spinnerFrames[r.frame%len(spinnerFrames)], r.msg, time.Since(r.start).Round(time.Second))
+	fmt.Fprintf(p.w, "\r\x1b[K%s", p.style(p.theme.Progress, line))
+}
+
+// clearProgress erases the active progress line so other output can take its
+// place. The caller holds p.mu.
+func (p *Printer) clearProgress() {
+	if p.progress != nil {
+		fmt.Fprint(p.w, "\r\x1b[K")
+	}
+}
@@ -0,0 +1,64 @@
+package pretty
+
+import "log/slog"
+
+// Style is an SGR parameter list such as "1;92" for bold bright green. An
+// empty Style prints the text unstyled.
+type Style string
+
+// Theme holds the style of each level and of unified diff lines.
+type Theme struct {
+	Debug    Style
+	Info     Style
+	Warn     Style
+	Error    Style
+	Progress Style
+
+	DiffHeader Style
+	DiffAdd    Style
+	DiffDelete Style
+	DiffHunk   Style
+}
+
+// DefaultTheme is bold green info, bold yellow warnings and bold red errors,
+// with debug lines dimmed.
+func DefaultTheme() Theme {
+	return Theme{
+		Debug:      "2",
+		Info:       "1;92",
+		Warn:       "1;93",
+		Error:      "1;91",
+		Progress:   "96",
+		DiffHeader: "1",
+		DiffAdd:    "92",
+		DiffDelete: "91",
+		DiffHunk:   "96",
+	}
+}
+
+// MonochromeTheme distinguishes levels by weight only, for terminals where
+// the default colors are hard to read.
+func MonochromeTheme() Theme {
+	return Theme{
+		Debug:      "2",
+		Warn:       "1",
+		Error:      "1;4",
+		DiffHeader: "1",
+		DiffDelete: "2",
+		DiffHunk:   "1",
+	}
+}
+
+// level returns the style of the highest level at or below l.
+func (t Theme) level(l slog.Level) Style {
+	switch {
+	case l >= slog.LevelError:
+		return t.Error
+	case l >= slog.LevelWarn:
+		return t.Warn
+	case l >= slog.LevelInfo:
+		return t.Info
+	default:
+		return t.Debug
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
+)
+
+// fieldManager is the server-side apply field manager for everything kubeinit applies.
+const fieldManager = "infra-kubeinit"
+
+// ApplyOptions controls server-side apply behaviour.
+type ApplyOptions struct {
+	// Force takes ownership of fields currently managed by someone else.
+	// Without it, such fields are reported as conflicts and the apply fails.
+	Force bool
+}
+
+func (o ApplyOptions) patchOptions() metav1.ApplyOptions {
+	return metav1.ApplyOptions{FieldManager: fieldManager, Force: o.Force}
+}
+
+// toApplyConfiguration converts a typed object into its client-go apply
+// configuration. Both share the same JSON representation.
+func toApplyConfiguration(obj any, applyConfig any) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("error marshaling object %w", err)
+	}
+	if err := json.Unmarshal(data, applyConfig); err != nil {
+		return fmt.Errorf("error converting object to apply configuration %w", err)
+	}
+	return nil
+}
+
+// deploymentApplyConfiguration converts a rendered Deployment for server-side apply.
+func deploymentApplyConfiguration(desired *appsv1.Deployment) (*appsv1ac.DeploymentApplyConfiguration, error) {
+	applyConfig := &appsv1ac.DeploymentApplyConfiguration{}
+	if err := toApplyConfiguration(desired, applyConfig); err != nil {
+		return nil, err
+	}
+	// Zero-valued structs survive the JSON round trip; don't claim them.
+	applyConfig.Status = nil
+	if desired.Spec.Strategy.Type == "" {
+		applyConfig.Spec.Strategy = nil
+	}
+	return applyConfig, nil
+}
+
+// reportApplyConflicts prints the fields another manager owns when an apply is
+// rejected, so drift made outside kubeinit is visible instead of overwritten.
+func reportApplyConflicts(kind string, name string, err error) {
+	var status apierrors.APIStatus
+	if !apierrors.IsConflict(err) || !errors.As(err, &status) {
+		return
+	}
+	pretty.PrintWarningf("%s %s has fields managed by others, rerun with -force-conflicts to take ownership:", kind, name)
+	if details := status.Status().Details; details != nil {
+		for _, cause := range details.Causes {
+			pretty.PrintWarningf("  %s: %s", cause.Field, cause.Message)
+		}
+	}
+}
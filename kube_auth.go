@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// AuthMode selects where the client's credentials come from.
+type AuthMode string
+
+const (
+	// AuthAuto uses the in-cluster service account when running in a pod and
+	// no kubeconfig, context or cluster was requested, otherwise the kubeconfig.
+	AuthAuto AuthMode = "auto"
+	// AuthInCluster uses the pod's service account token.
+	AuthInCluster AuthMode = "incluster"
+	// AuthKubeconfig uses the kubeconfig, as kubectl does.
+	AuthKubeconfig AuthMode = "kubeconfig"
+)
+
+// serviceAccountTokenPath is where the kubelet mounts the pod's service account token.
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+func ParseAuthMode(s string) (AuthMode, error) {
+	switch AuthMode(s) {
+	case "", AuthAuto:
+		return AuthAuto, nil
+	case AuthInCluster, AuthKubeconfig:
+		return AuthMode(s), nil
+	}
+	return AuthAuto, fmt.Errorf("invalid auth mode %q, expected auto, incluster or kubeconfig", s)
+}
+
+func WithAuthMode(mode AuthMode) KubeClientOption {
+	return func(k *KubeClient) {
+		k.AuthMode = mode
+	}
+}
+
+// inCluster reports whether the process runs in a pod with a service account token.
+func inCluster() bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+	_, err := os.Stat(serviceAccountTokenPath)
+	return err == nil
+}
+
+// Initialize creates the clients using the credentials selected by AuthMode.
+func (k *KubeClient) Initialize() error {
+	mode := k.AuthMode
+	if mode == AuthAuto || mode == "" {
+		mode = AuthKubeconfig
+		explicit := k.KubeconfigPath != "" || k.ContextName != "" || k.ClusterName != ""
+		if !explicit && inCluster() {
+			mode = AuthInCluster
+		}
+		slog.Debug("Detected auth mode", slog.String("mode", string(mode)))
+	}
+
+	if mode == AuthInCluster {
+		return k.InitializeInternalClient()
+	}
+	return k.InitializeExternalClient()
+}
@@ -4,31 +4,62 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"path/filepath"
 	"time"
 
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
 	"github.com/babbage88/infra-kubeinit/internal/pretty"
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	metav1util "k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
+// defaultOperationTimeout bounds single API requests when no timeout is configured.
+const defaultOperationTimeout = 30 * time.Second
+
 type IKubeClient interface {
 	New(opts ...KubeClientOption) *KubeClient
 }
 
 type KubeClient struct {
-	Client         *kubernetes.Clientset `json:"client"`
-	KubeconfigPath string                `json:"kubeconfigPath"`
-	Ctx            context.Context       `json:"context"`
+	Client         kubernetes.Interface      `json:"-"`
+	Dynamic        dynamic.Interface         `json:"-"`
+	Mapper         meta.ResettableRESTMapper `json:"-"`
+	Config         *rest.Config              `json:"-"`
+	KubeconfigPath string                    `json:"kubeconfigPath"`
+	ContextName    string                    `json:"contextName"`
+	ClusterName    string                    `json:"clusterName"`
+	Target         KubeTarget                `json:"target"`
+	AuthMode       AuthMode                  `json:"authMode"`
+	DryRun         DryRunMode                `json:"dryRun"`
+	// Ctx is the root context of every request; cancelling it stops in-flight
+	// requests, watches and log streams.
+	Ctx context.Context `json:"-"`
+	// OperationTimeout bounds each single API request. Waits use their own timeouts.
+	OperationTimeout time.Duration `json:"operationTimeout"`
+	// Retry is the backoff between attempts of a mutation that failed with a
+	// conflict or a transient error.
+	Retry wait.Backoff `json:"-"`
+}
+
+// KubeTarget identifies the kubeconfig context, cluster and user requests are sent as.
+type KubeTarget struct {
+	Context string `json:"context"`
+	Cluster string `json:"cluster"`
+	User    string `json:"user"`
+	Server  string `json:"server"`
+}
+
+func (t KubeTarget) String() string {
+	return fmt.Sprintf("context %s, cluster %s (%s), user %s", t.Context, t.Cluster, t.Server, t.User)
 }
 
 type KubeClientOption func(k *KubeClient)
@@ -39,19 +70,58 @@ func WithKubeconfigPath(s string) KubeClientOption {
 	}
 }
 
+// WithKubeContext selects a kubeconfig context other than the current one.
+func WithKubeContext(name string) KubeClientOption {
+	return func(k *KubeClient) {
+		k.ContextName = name
+	}
+}
+
+// WithKubeCluster sends requests to a kubeconfig cluster other than the one of the selected context.
+func WithKubeCluster(name string) KubeClientOption {
+	return func(k *KubeClient) {
+		k.ClusterName = name
+	}
+}
+
 func WithContext(ctx context.Context) KubeClientOption {
 	return func(k *KubeClient) {
 		k.Ctx = ctx
 	}
 }
 
+// WithOperationTimeout bounds each single API request, 0 disables the bound.
+func WithOperationTimeout(d time.Duration) KubeClientOption {
+	return func(k *KubeClient) {
+		k.OperationTimeout = d
+	}
+}
+
+// operationContext returns the context for a single API request, cancelled with
+// the root context or after OperationTimeout.
+func (k *KubeClient) operationContext() (context.Context, context.CancelFunc) {
+	if k.OperationTimeout <= 0 {
+		return context.WithCancel(k.Ctx)
+	}
+	return context.WithTimeout(k.Ctx, k.OperationTimeout)
+}
+
+// interrupted returns why the root context was cancelled, or nil while it is not.
+// Waits use it to tell cancellation apart from their own timeout.
+func (k *KubeClient) interrupted() error {
+	if k.Ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(k.Ctx)
+}
+
 func NewKubeClient(opts ...KubeClientOption) *KubeClient {
-	home := homedir.HomeDir()
-	defaultKubeconfigPath := filepath.Join(home, ".kube", "config")
-	ctx, _ := context.WithTimeout(context.Background(), time.Duration(time.Second*2))
 	k := &KubeClient{
-		KubeconfigPath: defaultKubeconfigPath,
-		Ctx:            ctx,
+		DryRun:           DryRunNone,
+		AuthMode:         AuthAuto,
+		Ctx:              context.Background(),
+		OperationTimeout: defaultOperationTimeout,
+		Retry:            retryBackoff(defaultRetryAttempts),
 	}
 
 	for _, opt := range opts {
@@ -69,42 +139,69 @@ func (k *KubeClient) InitializeInternalClient() error {
 		slog.Error("Error Initializing Internal KubeClient", slog.String("error", err.Error()))
 		return err
 	}
-	// creates the clientset
-	k.Client, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		slog.Error("Error Initializing Clientset for KubeClient", slog.String("error", err.Error()))
-		return err
-
-	}
-
-	return err
+	k.Target = KubeTarget{Context: "in-cluster", Cluster: "in-cluster", User: "pod service account", Server: config.Host}
+	return k.initializeClients(config)
 }
 
-// Initialize client from outside of a cluster.
+// Initialize client from outside of a cluster. The kubeconfig is loaded like
+// kubectl does: KubeconfigPath when set, otherwise the files listed in $KUBECONFIG
+// merged together, otherwise ~/.kube/config.
 func (k *KubeClient) InitializeExternalClient() error {
-	home := homedir.HomeDir()
-	if k.KubeconfigPath == "" {
-		k.KubeconfigPath = filepath.Join(home, ".kube", "config")
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = k.KubeconfigPath
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: k.ContextName}
+	overrides.Context.Cluster = k.ClusterName
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		slog.Error("Error Initializing External KubeClient", slog.String("error", err.Error()))
+		return err
 	}
-	config, err := clientcmd.BuildConfigFromFlags("", k.KubeconfigPath)
+
+	raw, err := clientConfig.RawConfig()
 	if err != nil {
-		slog.Error("Error Initializing Internal KubeClient", slog.String("error", err.Error()))
+		slog.Error("Error reading kubeconfig", slog.String("error", err.Error()))
 		return err
 	}
+	k.Target = KubeTarget{Context: raw.CurrentContext, Server: config.Host}
+	if k.ContextName != "" {
+		k.Target.Context = k.ContextName
+	}
+	if kubeContext, ok := raw.Contexts[k.Target.Context]; ok {
+		k.Target.Cluster = kubeContext.Cluster
+		k.Target.User = kubeContext.AuthInfo
+	}
+	if k.ClusterName != "" {
+		k.Target.Cluster = k.ClusterName
+	}
 
-	// creates the clientset
+	return k.initializeClients(config)
+}
+
+// initializeClients creates the typed and dynamic clients and the discovery
+// backed RESTMapper from a single rest config.
+func (k *KubeClient) initializeClients(config *rest.Config) error {
+	var err error
+	k.Config = config
 	k.Client, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		slog.Error("Error Initializing Clientset for KubeClient", slog.String("error", err.Error()))
 		return err
+	}
 
+	k.Dynamic, err = dynamic.NewForConfig(config)
+	if err != nil {
+		slog.Error("Error Initializing dynamic client for KubeClient", slog.String("error", err.Error()))
+		return err
 	}
 
-	return err
+	k.Mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(k.Client.Discovery()))
+	return nil
 }
 
 func NewDefaultExternalKubeClient() (*KubeClient, error) {
-	k := &KubeClient{}
+	k := NewKubeClient()
 	err := k.InitializeExternalClient()
 	if err != nil {
 		slog.Error("Error Initializing External Clientset for KubeClient", slog.String("error", err.Error()))
@@ -115,7 +212,7 @@ func NewDefaultExternalKubeClient() (*KubeClient, error) {
 }
 
 func NewInternalKubeClient() (*KubeClient, error) {
-	k := &KubeClient{}
+	k := NewKubeClient()
 	err := k.InitializeInternalClient()
 	if err != nil {
 		slog.Error("Error Initializing External Clientset for KubeClient", slog.String("error", err.Error()))
@@ -126,20 +223,24 @@ func NewInternalKubeClient() (*KubeClient, error) {
 }
 
 func (k *KubeClient) GetPods(namespace string, podName string) (*corev1.Pod, error) {
-	pod, err := k.Client.CoreV1().Pods(namespace).Get(k.Ctx, podName, metav1.GetOptions{})
+	ctx, cancel := k.operationContext()
+	defer cancel()
+	pod, err := k.Client.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
 		slog.Error("Error getting pods", slog.String("error", err.Error()))
 	}
-	pretty.Printf("Pod %s found in %s\n", podName, namespace)
-	pretty.Printf("Name: %s\n", pod.GetName())
-	pretty.Printf("uid: %s\n", pod.UID)
-	pretty.Printf("Created: %s\n", pod.CreationTimestamp.String())
+	pretty.Printf("Pod %s found in %s", podName, namespace)
+	pretty.Printf("Name: %s", pod.GetName())
+	pretty.Printf("uid: %s", pod.UID)
+	pretty.Printf("Created: %s", pod.CreationTimestamp.String())
 
 	return pod, err
 }
 
 func (k *KubeClient) ListJobs(namespace string) (*batchv1.JobList, error) {
-	job, err := k.Client.BatchV1().Jobs(namespace).List(k.Ctx, metav1.ListOptions{})
+	ctx, cancel := k.operationContext()
+	defer cancel()
+	job, err := k.Client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		slog.Error("Error getting pods", slog.String("error", err.Error()))
 	}
@@ -148,7 +249,9 @@ func (k *KubeClient) ListJobs(namespace string) (*batchv1.JobList, error) {
 }
 
 func (k *KubeClient) GetBatchJobByLabel(namespace string, label string) (*batchv1.JobList, error) {
-	job, err := k.Client.BatchV1().Jobs(namespace).List(k.Ctx, metav1.ListOptions{LabelSelector: label})
+	ctx, cancel := k.operationContext()
+	defer cancel()
+	job, err := k.Client.BatchV1().Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: label})
 	if err != nil {
 		slog.Error("Error retrieving jobs", slog.String("error", err.Error()))
 	}
@@ -156,256 +259,78 @@ func (k *KubeClient) GetBatchJobByLabel(namespace string, label string) (*batchv
 	return job, err
 }
 
-// createJob creates a Kubernetes Job using client-go
-func (k *KubeClient) CreateBatchJob(jobName string, namespace string, imageName string, volName string, secretName string, ttl *int32) error {
-	job := &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: jobName,
-		},
-		Spec: batchv1.JobSpec{
-			TTLSecondsAfterFinished: ttl,
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"workload":      "job",
-						"app":           "go-infra",
-						"workload-type": "db-migration",
-					},
-				},
-				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyOnFailure,
-					Containers: []corev1.Container{
-						{
-							Name:            jobName,
-							Image:           imageName,
-							ImagePullPolicy: corev1.PullAlways,
-							Command:         []string{"/app/migrate"},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      volName,
-									MountPath: "/app/.env",
-									SubPath:   ".env",
-								},
-							},
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									corev1.ResourceMemory: resource.MustParse("512Mi"),
-									corev1.ResourceCPU:    resource.MustParse("500m"),
-								},
-								Requests: corev1.ResourceList{
-									corev1.ResourceMemory: resource.MustParse("256Mi"),
-									corev1.ResourceCPU:    resource.MustParse("250m"),
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: volName,
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: secretName,
-								},
-							},
-						},
-					},
-					ImagePullSecrets: []corev1.LocalObjectReference{
-						{Name: "ghcr"},
-					},
-				},
-			},
-		},
+// CreateBatchJob renders the migration Job from the app spec and creates it.
+func (k *KubeClient) CreateBatchJob(jobName string, namespace string, spec *appspec.AppSpec, labels map[string]string, annotations map[string]string) error {
+	job := spec.MigrationJob(jobName, namespace, labels, annotations)
+	if job == nil {
+		return fmt.Errorf("app spec %s has no migration job", spec.Name)
+	}
+
+	if k.clientDryRun("create", job) {
+		return nil
 	}
 
 	// Create the Job
 	jobsClient := k.Client.BatchV1().Jobs(namespace)
-	_, err := jobsClient.Create(context.TODO(), job, metav1.CreateOptions{})
+	err := k.retryCreate("create Job "+job.Name, func(ctx context.Context) error {
+		_, err := jobsClient.Create(ctx, job, k.createOptions())
+		return err
+	}, func(ctx context.Context) (bool, error) {
+		// The name has a random suffix and the annotations record this invocation,
+		// so a Job carrying them was created by us.
+		live, err := jobsClient.Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for key, value := range job.Annotations {
+			if live.Annotations[key] != value {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
 	if err != nil {
 		slog.Error("failed to create job", slog.String("error", err.Error()))
 		return fmt.Errorf("Error creating job %w", err)
 	}
 
 	pretty.Printf("Job created successfully %s", job.Name)
-	slog.Info("Job created successfully\n", slog.String("name", job.Name))
-	return nil
-}
-
-func (k *KubeClient) CreateDeployment(namespace *string, deploymentName *string, replicas *int32, imageName *string, containerPort *int32) error {
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: *deploymentName,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: replicas,
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{
-					"app": *deploymentName,
-				},
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{
-						"app": *deploymentName,
-					},
-				},
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{
-						{
-							Name:            *deploymentName,
-							Image:           *imageName,
-							ImagePullPolicy: corev1.PullAlways,
-							Command:         []string{"/app/server"},
-							Ports: []corev1.ContainerPort{
-								{
-									ContainerPort: *containerPort,
-								},
-							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "cf-token-ini",
-									MountPath: "/run/secrets/cf_token.ini",
-									SubPath:   "cf_token.ini",
-								},
-								{
-									Name:      "k3s-env",
-									MountPath: "/app/.env",
-									SubPath:   "k3s.env",
-								},
-							},
-							Resources: corev1.ResourceRequirements{
-								Limits: corev1.ResourceList{
-									corev1.ResourceMemory: resource.MustParse("512Mi"),
-									corev1.ResourceCPU:    resource.MustParse("500m"),
-								},
-								Requests: corev1.ResourceList{
-									corev1.ResourceMemory: resource.MustParse("256Mi"),
-									corev1.ResourceCPU:    resource.MustParse("250m"),
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "cf-token-ini",
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: "cf-token-ini",
-								},
-							},
-						},
-						{
-							Name: "k3s-env",
-							VolumeSource: corev1.VolumeSource{
-								Secret: &corev1.SecretVolumeSource{
-									SecretName: "k3s-env",
-								},
-							},
-						},
-					},
-					ImagePullSecrets: []corev1.LocalObjectReference{
-						{Name: "ghcr"},
-					},
-				},
-			},
-		},
-	}
-
-	// Apply Deployment
-	deploymentsClient := k.Client.AppsV1().Deployments(*namespace)
-	_, err := deploymentsClient.Create(context.TODO(), deployment, metav1.CreateOptions{})
-	if err != nil {
-		slog.Error("Error creating deployment", slog.String("error", err.Error()))
-		return fmt.Errorf("failed to create deployment: %w", err)
-	}
-
-	slog.Info("Deployment created successfully", slog.String("deploymentName", *deploymentName))
+	slog.Info("Job created successfully", slog.String("name", job.Name))
 	return nil
 }
 
-func (k *KubeClient) CreateLoadBalancerService(namespace *string, serviceName *string, targetPort *int32, exposedPort *int32, appLabel *string, allocateNodePort *bool) error {
-	service := &corev1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      *serviceName,
-			Namespace: *namespace,
-			Labels: map[string]string{
-				"app": *appLabel,
-			},
-		},
-		Spec: corev1.ServiceSpec{
-			AllocateLoadBalancerNodePorts: allocateNodePort,
-			Selector: map[string]string{
-				"app": *appLabel,
-			},
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "http",
-					Port:       *exposedPort, // Exposed service port
-					TargetPort: metav1util.IntOrString{Type: metav1util.Int, IntVal: *targetPort},
-					Protocol:   corev1.ProtocolTCP,
-				},
-			},
-			Type: corev1.ServiceTypeLoadBalancer, // Exposes the service externally
-		},
-	}
-
-	// Create the Service
-	servicesClient := k.Client.CoreV1().Services(*namespace)
-	_, err := servicesClient.Create(context.TODO(), service, metav1.CreateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to create LoadBalancer Service: %w", err)
+// CreateOrUpdateDeployment server-side applies the Deployment rendered from the
+// app spec. Only fields set in the spec are owned by kubeinit; fields owned by
+// other managers are reported as conflicts unless opts.Force is set. When restart
+// is true the pod template is annotated to trigger a rollout even if nothing else changed.
+func (k *KubeClient) CreateOrUpdateDeployment(namespace *string, spec *appspec.AppSpec, restart bool, opts ApplyOptions) error {
+	desired := spec.Deployment(*namespace)
+	if restart {
+		if desired.Spec.Template.ObjectMeta.Annotations == nil {
+			desired.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
+		}
+		desired.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
 	}
 
-	slog.Info("LoadBalancer Service go-infra-service created successfully")
-	return nil
-}
-
-/*
-func (k *KubeClient) checkServiceExists(namespace string, serviceName string) (bool, error) {
-	service, err := k.Client.AppsV1().Services(namespace).Get(context.Background(), *&serviceName, metav1.GetOptions{})
-	if apierrors.IsNotFound(err) {
-		return false, nil
+	if k.clientDryRun("apply", desired) {
+		return nil
 	}
+	applyConfig, err := deploymentApplyConfiguration(desired)
 	if err != nil {
-		slog.Error("error performing get for service", slog.String("error", err.Error()), slog.String("serviceName", serviceName))
-		return false, err
+		return err
 	}
-	return true, nil
-}
-*/
 
-func (k *KubeClient) CreateOrUpdateDeployment(namespace, deploymentName *string, replicas *int32, imageName *string, containerPort *int32) error {
-	// Check if the deployment exists
-	deployment, err := k.Client.AppsV1().Deployments(*namespace).Get(context.Background(), *deploymentName, metav1.GetOptions{})
+	var deployment *appsv1.Deployment
+	err = k.retry("apply Deployment "+spec.Name, func(ctx context.Context) error {
+		deployment, err = k.Client.AppsV1().Deployments(*namespace).Apply(ctx, applyConfig, k.applyOptions(opts))
+		return err
+	})
 	if err != nil {
-		// If error is not a 404, log it
-		if apierrors.IsNotFound(err) {
-			slog.Info("Deployment does not exist in namespace", slog.String("deploymentName", *deploymentName), slog.String("namespace", *namespace))
-			err := k.CreateDeployment(namespace, deploymentName, replicas, imageName, containerPort)
-			if err != nil {
-				slog.Error("error creating deployment", slog.String("deploymentName", *deploymentName), slog.String("error", err.Error()))
-				return err
-			}
-			return err
-		} else {
-			deployment.Spec.Template.Spec.Containers[0].Image = *imageName
-			deployment.Spec.Replicas = replicas
-			deployment.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: *containerPort}}
-			// Trigger rollout restart by updating an annotation
-			if deployment.Spec.Template.ObjectMeta.Annotations == nil {
-				deployment.Spec.Template.ObjectMeta.Annotations = make(map[string]string)
-			}
-			deployment.Spec.Template.ObjectMeta.Annotations["kubectl.kubernetes.io/restartedAt"] = time.Now().Format(time.RFC3339)
-
-			_, err := k.Client.AppsV1().Deployments(deployment.Namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
-			if err != nil {
-				return fmt.Errorf("failed to update deployment: %w", err)
-			}
-
-			slog.Info("Deployment updated successfully", slog.String("deploymentName", deployment.Name))
-
-		}
+		reportApplyConflicts("Deployment", spec.Name, err)
+		slog.Error("Error applying deployment", slog.String("deploymentName", spec.Name), slog.String("error", err.Error()))
+		return fmt.Errorf("failed to apply deployment: %w", err)
 	}
 
-	// If the deployment exists, update it to trigger a restart
-	return err
+	slog.Info("Deployment applied successfully", slog.String("deploymentName", deployment.Name), slog.Int64("generation", deployment.Generation))
+	return nil
 }
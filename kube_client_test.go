@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeKubeClient returns a KubeClient backed by a fake clientset seeded with
+// objects. Retries back off for a millisecond so error paths stay fast.
+func newFakeKubeClient(t *testing.T, objects ...runtime.Object) (*KubeClient, *fake.Clientset) {
+	t.Helper()
+	clientset := fake.NewClientset(objects...)
+	k := NewKubeClient(WithRetryAttempts(3))
+	k.Client = clientset
+	k.Retry.Duration = time.Millisecond
+	return k, clientset
+}
+
+// newMigrationJob returns a migration Job for image that finished with
+// conditionType at finished.
+func newMigrationJob(name string, image string, conditionType batchv1.JobConditionType, finished time.Time) batchv1.Job {
+	labels, annotations := migrationJobMetadata("go-infra", image, "")
+	return batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Status: batchv1.JobStatus{
+			CompletionTime: &metav1.Time{Time: finished},
+			Conditions: []batchv1.JobCondition{{
+				Type:               conditionType,
+				Status:             corev1.ConditionTrue,
+				LastTransitionTime: metav1.Time{Time: finished},
+			}},
+		},
+	}
+}
+
+func TestNewKubeClientDefaults(t *testing.T) {
+	k := NewKubeClient()
+	if k.DryRun != DryRunNone {
+		t.Errorf("DryRun = %q, want %q", k.DryRun, DryRunNone)
+	}
+	if k.AuthMode != AuthAuto {
+		t.Errorf("AuthMode = %q, want %q", k.AuthMode, AuthAuto)
+	}
+	if k.OperationTimeout != defaultOperationTimeout {
+		t.Errorf("OperationTimeout = %s, want %s", k.OperationTimeout, defaultOperationTimeout)
+	}
+	if k.Retry.Steps != defaultRetryAttempts {
+		t.Errorf("Retry.Steps = %d, want %d", k.Retry.Steps, defaultRetryAttempts)
+	}
+	if k.Ctx == nil {
+		t.Fatal("Ctx is nil")
+	}
+
+	ctx, cancel := k.operationContext()
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Error("operation context has no deadline")
+	}
+}
+
+func TestCreateBatchJobRetry(t *testing.T) {
+	spec := appspec.Default("go-infra", "ghcr.io/babbage88/go-infra:v1.2.2", "ghcr.io/babbage88/init-infradb:v1.2.2", 8993, 1)
+	labels, annotations := migrationJobMetadata(spec.Name, spec.Migration.Container.Image, "")
+	jobsResource := schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+
+	tests := []struct {
+		name    string
+		foreign bool
+		wantErr bool
+	}{
+		{name: "timed out create that succeeded"},
+		{name: "name taken by another job", foreign: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, clientset := newFakeKubeClient(t)
+			attempts := 0
+			// The first create reaches the server but the response is lost.
+			clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				attempts++
+				if attempts > 1 {
+					return false, nil, nil
+				}
+				job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).DeepCopy()
+				if tt.foreign {
+					job.Annotations = map[string]string{annotationImage: "ghcr.io/babbage88/other:v1.0.0"}
+				}
+				if err := clientset.Tracker().Add(job); err != nil {
+					return true, nil, err
+				}
+				return true, nil, apierrors.NewTimeoutError("request timed out", 1)
+			})
+
+			err := k.CreateBatchJob("init-db-v1-2-2-abcde", "default", spec, labels, annotations)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateBatchJob() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr && !apierrors.IsAlreadyExists(err) {
+				t.Errorf("CreateBatchJob() error = %v, want AlreadyExists", err)
+			}
+			if attempts != 2 {
+				t.Errorf("create attempts = %d, want 2", attempts)
+			}
+			if _, err := clientset.Tracker().Get(jobsResource, "default", "init-db-v1-2-2-abcde"); err != nil {
+				t.Errorf("job not found after create %v", err)
+			}
+		})
+	}
+}
@@ -6,65 +6,160 @@ import (
 	"log/slog"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
-// Get the generic dynamic Resource Client
-func getGenericResourceClient(resourceType string, group string, version string) dynamic.ResourceInterface {
-	genericSchema := schema.GroupVersionResource{
-		Group:    group,
-		Version:  version,
-		Resource: resourceType,
+// RESTMapping resolves a kind to its resource using API discovery. The discovery
+// cache is refreshed once if the kind is unknown, e.g. for a freshly installed CRD.
+func (k *KubeClient) RESTMapping(gvk schema.GroupVersionKind) (*meta.RESTMapping, error) {
+	if k.Mapper == nil {
+		return nil, fmt.Errorf("kube client is not initialized")
+	}
+	mapping, err := k.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		k.Mapper.Reset()
+		mapping, err = k.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
 	}
-
-	dynamicClient, err := dynamic.NewForConfig(&rest.Config{})
 	if err != nil {
-		slog.Error("error creating dynamic client", slog.String("error", err.Error()))
+		return nil, fmt.Errorf("error resolving resource for %s %w", gvk.String(), err)
 	}
-	// Attach the given resource and schema
-	return dynamicClient.Resource(genericSchema).Namespace("Namespace")
+	return mapping, nil
 }
 
-// Main method to deploy any type of kubernets kind
-func genericDeployment(resourceType string, resourceName string, resourceObject runtime.Object, group string, version string) error {
-	// Context to consider
-	Ctx := context.Background()
+// toUnstructured converts a typed or unstructured object, filling in its kind
+// from the client-go scheme when the typed object has no TypeMeta.
+func toUnstructured(obj runtime.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.DeepCopy(), nil
+	}
 
-	// 1. Get the dynamic resouce client
-	resourceClient := getGenericResourceClient(resourceType, group, version)
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, fmt.Errorf("error converting object to unstructured %w", err)
+	}
+	u := &unstructured.Unstructured{Object: content}
+	if u.GetKind() == "" {
+		kinds, _, err := scheme.Scheme.ObjectKinds(obj)
+		if err != nil || len(kinds) == 0 {
+			return nil, fmt.Errorf("unable to determine kind of %T %w", obj, err)
+		}
+		u.SetGroupVersionKind(kinds[0])
+	}
+	return u, nil
+}
 
-	// 2. convert runtimeObject to unstructured
-	unstructuredObj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(resourceObject)
+// objectClient returns the dynamic client for the object's kind, defaulting the
+// object's namespace for namespaced kinds and clearing it for cluster-scoped ones.
+func (k *KubeClient) objectClient(obj *unstructured.Unstructured, namespace string) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := k.RESTMapping(gvk)
 	if err != nil {
-		return fmt.Errorf("Found error while coverting resource to unstructured err - %s", err)
+		return nil, err
 	}
-	unstructuredResource := &unstructured.Unstructured{Object: unstructuredObj}
 
-	// 3. try to see if the resource exists
-	existingResource, err := resourceClient.Get(context.Background(), resourceName, metav1.GetOptions{})
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return k.Dynamic.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() == "" {
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+		obj.SetNamespace(namespace)
+	}
+	return k.Dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// CreateOrUpdateObject creates any kind of object, or replaces the existing one,
+// for `apply -server-side=false`. Updates carry the live resourceVersion; on a
+// conflict the object is re-read and the update retried. namespace is used when
+// the object has none.
+func (k *KubeClient) CreateOrUpdateObject(resourceObject runtime.Object, namespace string) (*unstructured.Unstructured, error) {
+	obj, err := toUnstructured(resourceObject)
 	if err != nil {
+		return nil, err
+	}
+	resourceClient, err := k.objectClient(obj, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, name := obj.GetKind(), obj.GetName()
+	var result *unstructured.Unstructured
+	err = k.retry("create or update "+kind+" "+name, func(ctx context.Context) error {
+		existing, err := resourceClient.Get(ctx, name, metav1.GetOptions{})
 		if apierrors.IsNotFound(err) {
-			// Resource doesn't exist, so create one
-			_, err = resourceClient.Create(Ctx, unstructuredResource, metav1.CreateOptions{})
+			if k.clientDryRun("create", obj) {
+				result = obj
+				return nil
+			}
+			created, err := resourceClient.Create(ctx, obj, k.createOptions())
 			if err != nil {
-				return fmt.Errorf("Found error while creating the Resource : %s, err - %s", resourceName, err)
+				return fmt.Errorf("error creating %s %s %w", kind, name, err)
 			}
-		} else {
-			return fmt.Errorf("Found error initialising the client : %s, err - %s", resourceType, err)
+			slog.Info("Resource created", slog.String("kind", kind), slog.String("name", name), slog.String("namespace", obj.GetNamespace()))
+			result = created
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting %s %s %w", kind, name, err)
+		}
+
+		obj.SetResourceVersion(existing.GetResourceVersion())
+		if k.clientDryRun("update", obj) {
+			result = obj
+			return nil
 		}
-	} else {
-		// Resource already exists, so update the existing resource
-		existingResource.Object = unstructuredObj
-		_, err := resourceClient.Update(Ctx, existingResource, metav1.UpdateOptions{})
+		updated, err := resourceClient.Update(ctx, obj, k.updateOptions())
 		if err != nil {
-			return fmt.Errorf("Found error while Updating %s, - err : %s", resourceType, err)
+			return fmt.Errorf("error updating %s %s %w", kind, name, err)
 		}
+		slog.Info("Resource updated", slog.String("kind", kind), slog.String("name", name), slog.String("namespace", obj.GetNamespace()))
+		result = updated
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ApplyObject server-side applies any kind of object with the kubeinit field
+// manager. namespace is used when a namespaced object has none.
+func (k *KubeClient) ApplyObject(resourceObject runtime.Object, namespace string, opts ApplyOptions) (*unstructured.Unstructured, error) {
+	obj, err := toUnstructured(resourceObject)
+	if err != nil {
+		return nil, err
+	}
+	resourceClient, err := k.objectClient(obj, namespace)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// Apply requests must not carry server-populated metadata.
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	unstructured.RemoveNestedField(obj.Object, "status")
+
+	if k.clientDryRun("apply", obj) {
+		return obj, nil
+	}
+	kind, name := obj.GetKind(), obj.GetName()
+	var applied *unstructured.Unstructured
+	err = k.retry("apply "+kind+" "+name, func(ctx context.Context) error {
+		applied, err = resourceClient.Apply(ctx, name, obj, k.applyOptions(opts))
+		return err
+	})
+	if err != nil {
+		reportApplyConflicts(kind, name, err)
+		return nil, fmt.Errorf("error applying %s %s %w", kind, name, err)
+	}
+	slog.Info("Resource applied", slog.String("kind", kind), slog.String("name", name), slog.String("namespace", obj.GetNamespace()))
+	return applied, nil
 }
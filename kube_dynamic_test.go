@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// staticMapper serves a fixed set of mappings in place of API discovery.
+type staticMapper struct {
+	meta.RESTMapper
+}
+
+func (staticMapper) Reset() {}
+
+func newFakeDynamicKubeClient(t *testing.T, objects ...runtime.Object) (*KubeClient, *dynamicfake.FakeDynamicClient) {
+	t.Helper()
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{corev1.SchemeGroupVersion})
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+	mapper.Add(corev1.SchemeGroupVersion.WithKind("Namespace"), meta.RESTScopeRoot)
+
+	k, _ := newFakeKubeClient(t)
+	client := dynamicfake.NewSimpleDynamicClient(scheme.Scheme, objects...)
+	k.Dynamic = client
+	k.Mapper = staticMapper{mapper}
+	return k, client
+}
+
+func TestCreateOrUpdateObject(t *testing.T) {
+	configMap := func(value string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "staging", ResourceVersion: "7"},
+			Data:       map[string]string{"mode": value},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		wantVerb string
+	}{
+		{name: "creates a missing object", wantVerb: "create"},
+		{name: "updates an existing object", existing: []runtime.Object{configMap("old")}, wantVerb: "update"},
+	}
+	// The manifest carries no resourceVersion; updates must use the live one.
+	manifest := func() *corev1.ConfigMap {
+		cm := configMap("new")
+		cm.ResourceVersion = ""
+		return cm
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, client := newFakeDynamicKubeClient(t, tt.existing...)
+			result, err := k.CreateOrUpdateObject(manifest(), "default")
+			if err != nil {
+				t.Fatalf("CreateOrUpdateObject() error = %v", err)
+			}
+			if result.GetNamespace() != "staging" {
+				t.Errorf("namespace = %q, want the object's own namespace", result.GetNamespace())
+			}
+
+			actions := client.Actions()
+			if got := actions[len(actions)-1].GetVerb(); got != tt.wantVerb {
+				t.Errorf("last request = %s, want %s", got, tt.wantVerb)
+			}
+			live, err := client.Resource(corev1.SchemeGroupVersion.WithResource("configmaps")).Namespace("staging").Get(context.Background(), "settings", metav1.GetOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if live.Object["data"].(map[string]any)["mode"] != "new" {
+				t.Errorf("data = %v, want mode=new", live.Object["data"])
+			}
+		})
+	}
+
+	t.Run("conflicting update re-reads the object", func(t *testing.T) {
+		k, client := newFakeDynamicKubeClient(t, configMap("old"))
+		conflicts := 0
+		client.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if conflicts > 0 {
+				return false, nil, nil
+			}
+			conflicts++
+			return true, nil, apierrors.NewConflict(corev1.Resource("configmaps"), "settings", errors.New("object has been modified"))
+		})
+
+		if _, err := k.CreateOrUpdateObject(manifest(), "default"); err != nil {
+			t.Fatalf("CreateOrUpdateObject() error = %v", err)
+		}
+		var gets, updates int
+		for _, action := range client.Actions() {
+			switch action.GetVerb() {
+			case "get":
+				gets++
+			case "update":
+				updates++
+				obj := action.(k8stesting.UpdateAction).GetObject().(*unstructured.Unstructured)
+				if obj.GetResourceVersion() != "7" {
+					t.Errorf("update resourceVersion = %q, want the live 7", obj.GetResourceVersion())
+				}
+			}
+		}
+		if gets != 2 || updates != 2 {
+			t.Errorf("got %d gets and %d updates, want the object re-read before a second update", gets, updates)
+		}
+	})
+
+	t.Run("cluster-scoped kinds drop the namespace", func(t *testing.T) {
+		k, _ := newFakeDynamicKubeClient(t)
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging"}}
+		result, err := k.CreateOrUpdateObject(namespace, "default")
+		if err != nil {
+			t.Fatalf("CreateOrUpdateObject() error = %v", err)
+		}
+		if result.GetNamespace() != "" {
+			t.Errorf("namespace = %q, want none", result.GetNamespace())
+		}
+	})
+}
+
+func TestApplyObject(t *testing.T) {
+	tests := []struct {
+		name          string
+		object        runtime.Object
+		wantNamespace string
+	}{
+		{
+			name: "keeps the object's namespace",
+			object: &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "staging", ResourceVersion: "42"},
+				Data:       map[string]string{"mode": "new"},
+			},
+			wantNamespace: "staging",
+		},
+		{
+			name:          "defaults the namespace",
+			object:        &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings"}},
+			wantNamespace: "default",
+		},
+		{
+			name:   "cluster-scoped kinds drop the namespace",
+			object: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "staging", Namespace: "default"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, client := newFakeDynamicKubeClient(t)
+			// The fake tracker cannot apply, so echo the request back as the live object.
+			client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				obj := &unstructured.Unstructured{}
+				return true, obj, obj.UnmarshalJSON(action.(k8stesting.PatchAction).GetPatch())
+			})
+
+			applied, err := k.ApplyObject(tt.object, "default", ApplyOptions{})
+			if err != nil {
+				t.Fatalf("ApplyObject() error = %v", err)
+			}
+			if applied.GetNamespace() != tt.wantNamespace {
+				t.Errorf("namespace = %q, want %q", applied.GetNamespace(), tt.wantNamespace)
+			}
+			if applied.GetResourceVersion() != "" {
+				t.Errorf("resourceVersion = %q, want none in an apply request", applied.GetResourceVersion())
+			}
+
+			actions := client.Actions()
+			if len(actions) != 1 {
+				t.Fatalf("requests = %v, want a single apply", actions)
+			}
+			patch := actions[0].(k8stesting.PatchAction)
+			if patch.GetPatchType() != types.ApplyPatchType || patch.GetNamespace() != tt.wantNamespace {
+				t.Errorf("request = %s %s in %q, want an apply in %q", patch.GetVerb(), patch.GetPatchType(), patch.GetNamespace(), tt.wantNamespace)
+			}
+		})
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+var ErrJobFailed = errors.New("job failed")
+
+const (
+	migrationJobPrefix    = "init-db"
+	migrationLabel        = "workload-type=db-migration"
+	labelImageTag         = "infra-kubeinit/image-tag"
+	labelReleaseVersion   = "infra-kubeinit/release"
+	annotationImage       = "infra-kubeinit/image"
+	annotationImageDigest = "infra-kubeinit/image-digest"
+	annotationInvocation  = "infra-kubeinit/invocation"
+	annotationInvokedBy   = "infra-kubeinit/invoked-by"
+	annotationInvokedAt   = "infra-kubeinit/invoked-at"
+	maxKubeNameLength     = 63
+	migrationSuffixLength = 5
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// imageTag returns the tag of an image reference, ignoring any digest.
+// Images without a tag are reported as "latest".
+func imageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	lastSlash := strings.LastIndex(image, "/")
+	if i := strings.LastIndex(image, ":"); i > lastSlash {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// sanitizeName lowercases s and replaces anything that is not valid in a
+// DNS-1123 label, truncating the result to maxLen.
+func sanitizeName(s string, maxLen int) string {
+	s = invalidNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	if len(s) > maxLen {
+		s = s[:maxLen]
+	}
+	return strings.Trim(s, "-")
+}
+
+// migrationJobName generates a unique name of the form init-db-<image-tag>-<suffix>.
+func migrationJobName(image string) string {
+	maxTag := maxKubeNameLength - len(migrationJobPrefix) - migrationSuffixLength - 2
+	tag := sanitizeName(imageTag(image), maxTag)
+	suffix := utilrand.String(migrationSuffixLength)
+	if tag == "" {
+		return fmt.Sprintf("%s-%s", migrationJobPrefix, suffix)
+	}
+	return fmt.Sprintf("%s-%s-%s", migrationJobPrefix, tag, suffix)
+}
+
+// migrationJobMetadata returns the labels and annotations recorded on a migration
+// Job so its image, the release that triggered it and the kubeinit invocation can
+// be traced later.
+func migrationJobMetadata(app string, image string, version string) (map[string]string, map[string]string) {
+	labels := map[string]string{
+		"workload":      "job",
+		"app":           app,
+		"workload-type": "db-migration",
+		labelImageTag:   sanitizeName(imageTag(image), maxKubeNameLength),
+	}
+	if version != "" {
+		labels[labelReleaseVersion] = sanitizeName(version, maxKubeNameLength)
+	}
+
+	invokedBy := "unknown"
+	if u, err := user.Current(); err == nil {
+		invokedBy = u.Username
+	}
+	if host, err := os.Hostname(); err == nil {
+		invokedBy = fmt.Sprintf("%s@%s", invokedBy, host)
+	}
+	annotations := map[string]string{
+		annotationImage:      image,
+		annotationInvocation: strings.Join(os.Args, " "),
+		annotationInvokedBy:  invokedBy,
+		annotationInvokedAt:  time.Now().UTC().Format(time.RFC3339),
+	}
+	return labels, annotations
+}
+
+// MigrationHistorySelector returns the label selector matching migration Jobs,
+// optionally narrowed to a single app and release. Apps can share a namespace,
+// so decisions about one app must only look at its own Jobs.
+func MigrationHistorySelector(app string, version string) string {
+	selector := migrationLabel
+	if app != "" {
+		selector = fmt.Sprintf("%s,app=%s", selector, app)
+	}
+	if version != "" {
+		selector = fmt.Sprintf("%s,%s=%s", selector, labelReleaseVersion, sanitizeName(version, maxKubeNameLength))
+	}
+	return selector
+}
+
+// RecordJobImageDigest annotates the Job with the digest of the image its pod
+// actually ran, taken from the container status image ID.
+func (k *KubeClient) RecordJobImageDigest(namespace string, jobName string) error {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	pods, err := k.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: jobPodSelector(jobName)})
+	if err != nil {
+		return fmt.Errorf("error listing pods for job %s %w", jobName, err)
+	}
+
+	var digest string
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			if d := imageDigest(status.ImageID); d != "" {
+				digest = d
+			}
+		}
+	}
+	if digest == "" {
+		return fmt.Errorf("no image digest found in pod status for job %s", jobName)
+	}
+
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{annotationImageDigest: digest},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	err = k.retry("annotate Job "+jobName, func(ctx context.Context) error {
+		_, err := k.Client.BatchV1().Jobs(namespace).Patch(ctx, jobName, types.MergePatchType, patch, k.patchOptions())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error annotating job %s %w", jobName, err)
+	}
+	return nil
+}
+
+// jobFinished reports whether the Job has reached a terminal condition. The
+// returned error is non-nil when the terminal condition is JobFailed.
+func jobFinished(job *batchv1.Job) (bool, error) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return true, fmt.Errorf("%w: %s reason: %s message: %s", ErrJobFailed, job.Name, condition.Reason, condition.Message)
+		}
+	}
+	return false, nil
+}
+
+// JobSummary is the state of a migration Job as reported in command results.
+// Completed is when the Job reached its final condition, successful or not.
+type JobSummary struct {
+	Name      string       `json:"name"`
+	Image     string       `json:"image"`
+	Tag       string       `json:"tag"`
+	Status    string       `json:"status"`
+	Reason    string       `json:"reason,omitempty"`
+	Created   metav1.Time  `json:"created"`
+	Started   *metav1.Time `json:"started,omitempty"`
+	Completed *metav1.Time `json:"completed,omitempty"`
+	Duration  string       `json:"duration,omitempty"`
+	Succeeded int32        `json:"succeeded"`
+	Failed    int32        `json:"failed"`
+}
+
+// terminalCondition returns the Job's true JobComplete or JobFailed condition,
+// or nil while it is running.
+func terminalCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i, condition := range job.Status.Conditions {
+		if condition.Status == corev1.ConditionTrue &&
+			(condition.Type == batchv1.JobComplete || condition.Type == batchv1.JobFailed) {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// summarizeJob returns the summary of job, with Status Complete, Failed or Running.
+func summarizeJob(job *batchv1.Job) JobSummary {
+	summary := JobSummary{
+		Name:      job.Name,
+		Image:     jobImage(job),
+		Status:    "Running",
+		Created:   job.CreationTimestamp,
+		Started:   job.Status.StartTime,
+		Completed: job.Status.CompletionTime,
+		Succeeded: job.Status.Succeeded,
+		Failed:    job.Status.Failed,
+	}
+	if summary.Image != "" {
+		summary.Tag = imageTag(summary.Image)
+	} else {
+		summary.Tag = job.Labels[labelImageTag]
+	}
+	if condition := terminalCondition(job); condition != nil {
+		summary.Status, summary.Reason = string(condition.Type), condition.Reason
+		if summary.Completed == nil {
+			summary.Completed = condition.LastTransitionTime.DeepCopy()
+		}
+	}
+	if summary.Started != nil && summary.Completed != nil {
+		summary.Duration = summary.Completed.Sub(summary.Started.Time).Round(time.Second).String()
+	}
+	return summary
+}
+
+// WatchJobs calls onChange for every Job matching selector in namespace that is
+// added, updated or deleted, until the root context is done. It returns once
+// the informer has stopped. Calls are made one at a time.
+func (k *KubeClient) WatchJobs(namespace string, selector string, onChange func(job *batchv1.Job, deleted bool)) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(k.Client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = selector
+		}))
+	informer := factory.Batch().V1().Jobs().Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if job, ok := obj.(*batchv1.Job); ok {
+				onChange(job, false)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if job, ok := obj.(*batchv1.Job); ok {
+				onChange(job, false)
+			}
+		},
+		DeleteFunc: func(obj any) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if job, ok := obj.(*batchv1.Job); ok {
+				onChange(job, true)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error adding job event handler %w", err)
+	}
+
+	factory.Start(k.Ctx.Done())
+	defer factory.Shutdown()
+	<-k.Ctx.Done()
+	return nil
+}
+
+// WaitForJobCompletion blocks until the Job reaches JobComplete or JobFailed,
+// or until timeout elapses. The watch is re-established if the apiserver closes it.
+func (k *KubeClient) WaitForJobCompletion(namespace string, jobName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(k.Ctx, timeout)
+	defer cancel()
+
+	jobsClient := k.Client.BatchV1().Jobs(namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", jobName).String()
+
+	progress := pretty.StartProgress(fmt.Sprintf("Waiting up to %s for job %s to finish", timeout, jobName))
+	defer progress.Stop()
+	for {
+		job, err := jobsClient.Get(ctx, jobName, metav1.GetOptions{})
+		if err != nil {
+			if cause := k.interrupted(); cause != nil {
+				return fmt.Errorf("stopped waiting for job %s %w", jobName, cause)
+			}
+			slog.Error("Error getting job", slog.String("job", jobName), slog.String("error", err.Error()))
+			return fmt.Errorf("error getting job %s %w", jobName, err)
+		}
+		if done, err := jobFinished(job); done {
+			return err
+		}
+
+		watcher, err := jobsClient.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   selector,
+			ResourceVersion: job.ResourceVersion,
+		})
+		if err != nil {
+			slog.Error("Error watching job", slog.String("job", jobName), slog.String("error", err.Error()))
+			return fmt.Errorf("error watching job %s %w", jobName, err)
+		}
+
+		done, err := watchJob(ctx, watcher)
+		watcher.Stop()
+		if done {
+			return err
+		}
+		if cause := k.interrupted(); cause != nil {
+			return fmt.Errorf("stopped waiting for job %s %w", jobName, cause)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s waiting for job %s %w", timeout, jobName, ctx.Err())
+		}
+		slog.Info("Job watch closed, re-establishing", slog.String("job", jobName))
+	}
+}
+
+// watchJob consumes events until the Job finishes, the watch closes or ctx is done.
+func watchJob(ctx context.Context, watcher watch.Interface) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			switch event.Type {
+			case watch.Deleted:
+				return true, fmt.Errorf("job was deleted before it finished")
+			case watch.Error:
+				return false, nil
+			}
+			job, ok := event.Object.(*batchv1.Job)
+			if !ok {
+				continue
+			}
+			slog.Debug("Job status", slog.String("job", job.Name),
+				slog.Int("active", int(job.Status.Active)),
+				slog.Int("succeeded", int(job.Status.Succeeded)),
+				slog.Int("failed", int(job.Status.Failed)))
+			if done, err := jobFinished(job); done {
+				return done, err
+			}
+		}
+	}
+}
+
+// DeleteJob deletes the Job, letting the garbage collector remove its pods in the background.
+func (k *KubeClient) DeleteJob(namespace string, jobName string) error {
+	if k.DryRun == DryRunClient {
+		pretty.Printf("# dry-run (client): would delete job %s", jobName)
+		return nil
+	}
+	propagation := metav1.DeletePropagationBackground
+	err := k.retry("delete Job "+jobName, func(ctx context.Context) error {
+		return k.Client.BatchV1().Jobs(namespace).Delete(ctx, jobName, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+			DryRun:            k.serverDryRun(),
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting job %s %w", jobName, err)
+	}
+	slog.Info("Job deleted", slog.String("name", jobName), slog.String("namespace", namespace))
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	logPollInterval = 2 * time.Second
+	logDrainTimeout = 5 * time.Second
+)
+
+func jobPodSelector(jobName string) string {
+	return fmt.Sprintf("job-name=%s", jobName)
+}
+
+// containerRestarts returns the restart count of the first container in the pod.
+func containerRestarts(pod *corev1.Pod) (int32, bool) {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return 0, false
+	}
+	status := pod.Status.ContainerStatuses[0]
+	started := status.State.Running != nil || status.State.Terminated != nil
+	return status.RestartCount, started
+}
+
+// StreamJobLogs follows the logs of every pod belonging to the Job until ctx is
+// cancelled. Each line is prefixed with the pod name. When a container is restarted
+// under RestartPolicyOnFailure the new container instance is followed as well.
+// Once ctx is done, open streams get logDrainTimeout to deliver their last lines.
+func (k *KubeClient) StreamJobLogs(ctx context.Context, namespace string, jobName string) {
+	var wg sync.WaitGroup
+	followCtx, stopFollowing := context.WithCancel(k.Ctx)
+	defer stopFollowing()
+	defer wg.Wait()
+	go func() {
+		<-ctx.Done()
+		time.AfterFunc(logDrainTimeout, stopFollowing)
+	}()
+
+	// pod name -> restart count of the container instance already being followed
+	following := make(map[string]int32)
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pods, err := k.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: jobPodSelector(jobName)})
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Error listing job pods", slog.String("job", jobName), slog.String("error", err.Error()))
+		}
+		if pods != nil {
+			for i := range pods.Items {
+				pod := &pods.Items[i]
+				restarts, started := containerRestarts(pod)
+				if !started {
+					continue
+				}
+				if last, ok := following[pod.Name]; ok && last >= restarts {
+					continue
+				}
+				following[pod.Name] = restarts
+				wg.Add(1)
+				go func(podName string, restarts int32) {
+					defer wg.Done()
+					k.followPodLogs(followCtx, namespace, podName, restarts)
+				}(pod.Name, restarts)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (k *KubeClient) followPodLogs(ctx context.Context, namespace string, podName string, restarts int32) {
+	stream, err := k.Client.CoreV1().Pods(namespace).GetLogs(podName, &corev1.PodLogOptions{Follow: true}).Stream(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Error streaming pod logs", slog.String("pod", podName), slog.String("error", err.Error()))
+		}
+		return
+	}
+	defer stream.Close()
+
+	prefix := podName
+	if restarts > 0 {
+		prefix = fmt.Sprintf("%s#%d", podName, restarts)
+	}
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		pretty.Printf("[%s] %s", prefix, scanner.Text())
+	}
+}
+
+// TailJobLogs returns the last tailLines lines of logs from the most recent failing
+// pod of the Job. If the container is waiting to be restarted, the logs of the
+// previous terminated instance are used.
+func (k *KubeClient) TailJobLogs(namespace string, jobName string, tailLines int64) (string, error) {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	pods, err := k.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: jobPodSelector(jobName)})
+	if err != nil {
+		return "", fmt.Errorf("error listing pods for job %s %w", jobName, err)
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no pods found for job %s", jobName)
+	}
+
+	sort.Slice(pods.Items, func(i, j int) bool {
+		return pods.Items[i].CreationTimestamp.After(pods.Items[j].CreationTimestamp.Time)
+	})
+	pod := &pods.Items[0]
+	for i := range pods.Items {
+		if podFailed(&pods.Items[i]) {
+			pod = &pods.Items[i]
+			break
+		}
+	}
+
+	opts := &corev1.PodLogOptions{TailLines: &tailLines}
+	if len(pod.Status.ContainerStatuses) > 0 {
+		status := pod.Status.ContainerStatuses[0]
+		opts.Previous = status.State.Waiting != nil && status.RestartCount > 0
+	}
+	stream, err := k.Client.CoreV1().Pods(namespace).GetLogs(pod.Name, opts).Stream(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting logs for pod %s %w", pod.Name, err)
+	}
+	defer stream.Close()
+
+	logs, err := io.ReadAll(stream)
+	if err != nil {
+		return "", fmt.Errorf("error reading logs for pod %s %w", pod.Name, err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "last %d lines of pod %s:\n", tailLines, pod.Name)
+	b.Write(logs)
+	return b.String(), nil
+}
+
+func podFailed(pod *corev1.Pod) bool {
+	if pod.Status.Phase == corev1.PodFailed {
+		return true
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.RestartCount > 0 {
+			return true
+		}
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// EnsureNamespace creates the namespace with labels if it does not exist. When it
+// exists, labels missing or different on the live namespace are patched in;
+// other labels are left alone.
+func (k *KubeClient) EnsureNamespace(name string, labels map[string]string) error {
+	return k.retry("ensure Namespace "+name, func(ctx context.Context) error {
+		namespacesClient := k.Client.CoreV1().Namespaces()
+		live, err := namespacesClient.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			namespace := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			}
+			if k.clientDryRun("create", namespace) {
+				return nil
+			}
+			if _, err := namespacesClient.Create(ctx, namespace, k.createOptions()); err != nil {
+				return fmt.Errorf("error creating namespace %s %w", name, err)
+			}
+			pretty.Printf("Namespace %s created", name)
+			slog.Info("Namespace created", slog.String("name", name))
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error getting namespace %s %w", name, err)
+		}
+
+		changed := make(map[string]string)
+		for key, value := range labels {
+			if live.Labels[key] != value {
+				changed[key] = value
+			}
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		if k.DryRun == DryRunClient {
+			pretty.Printf("# dry-run (client): would label namespace %s with %v", name, changed)
+			return nil
+		}
+		patch, err := json.Marshal(map[string]any{
+			"metadata": map[string]any{"labels": changed},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := namespacesClient.Patch(ctx, name, types.MergePatchType, patch, k.patchOptions()); err != nil {
+			return fmt.Errorf("error labeling namespace %s %w", name, err)
+		}
+		slog.Info("Namespace labels updated", slog.String("name", name))
+		return nil
+	})
+}
+
+// VerifySecrets returns an error naming every Secret in names that does not exist
+// in namespace, so a deploy fails before starting pods that cannot mount them.
+func (k *KubeClient) VerifySecrets(namespace string, names []string) error {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	var missing []string
+	for _, name := range names {
+		_, err := k.Client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error getting secret %s %w", name, err)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing secrets in namespace %s: %s", namespace, strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// defaultRetryAttempts is how often a mutation is tried before giving up.
+const defaultRetryAttempts = 5
+
+// retryBackoff returns the backoff between attempts: 250ms doubling up to 10s.
+func retryBackoff(attempts int) wait.Backoff {
+	return wait.Backoff{
+		Steps:    attempts,
+		Duration: 250 * time.Millisecond,
+		Factor:   2,
+		Jitter:   0.1,
+		Cap:      10 * time.Second,
+	}
+}
+
+// WithRetryAttempts sets how often mutations are tried, 1 disables retries.
+func WithRetryAttempts(attempts int) KubeClientOption {
+	return func(k *KubeClient) {
+		if attempts < 1 {
+			attempts = 1
+		}
+		k.Retry = retryBackoff(attempts)
+	}
+}
+
+// isTransient reports whether err is worth retrying unchanged: timeouts,
+// throttling, 5xx responses and dropped connections.
+func isTransient(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Code >= 500 {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || utilnet.IsConnectionReset(err) ||
+		utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err)
+}
+
+// isFieldManagerConflict reports whether err is a server-side apply conflict,
+// which only -force-conflicts resolves.
+func isFieldManagerConflict(err error) bool {
+	var status apierrors.APIStatus
+	if !apierrors.IsConflict(err) || !errors.As(err, &status) {
+		return false
+	}
+	if details := status.Status().Details; details != nil {
+		for _, cause := range details.Causes {
+			if cause.Type == "FieldManagerConflict" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryCreate is retry for creating a new object. A create that timed out may
+// still have succeeded on the server, so AlreadyExists on a later attempt is
+// not an error if created reports that the existing object is the one an
+// earlier attempt created.
+func (k *KubeClient) retryCreate(operation string, create func(ctx context.Context) error, created func(ctx context.Context) (bool, error)) error {
+	attempted := false
+	return k.retry(operation, func(ctx context.Context) error {
+		err := create(ctx)
+		if apierrors.IsAlreadyExists(err) && attempted {
+			ours, getErr := created(ctx)
+			if getErr != nil {
+				return getErr
+			}
+			if ours {
+				slog.Info("Object was created by an earlier attempt", slog.String("operation", operation))
+				return nil
+			}
+		}
+		attempted = true
+		return err
+	})
+}
+
+// retry calls fn with a fresh request context until it succeeds, fails with an
+// error that is not transient, or the attempts run out. Update conflicts are
+// retried too, so fn must re-read the object it changes on every call. Each retry
+// is logged.
+func (k *KubeClient) retry(operation string, fn func(ctx context.Context) error) error {
+	backoff := k.Retry
+	if backoff.Steps < 1 {
+		backoff = retryBackoff(defaultRetryAttempts)
+	}
+	attempts := backoff.Steps
+
+	var lastErr error
+	attempt := 0
+	err := wait.ExponentialBackoffWithContext(k.Ctx, backoff, func(context.Context) (bool, error) {
+		attempt++
+		ctx, cancel := k.operationContext()
+		defer cancel()
+
+		lastErr = fn(ctx)
+		if lastErr == nil {
+			return true, nil
+		}
+		if k.Ctx.Err() != nil {
+			return false, lastErr
+		}
+		conflict := apierrors.IsConflict(lastErr) && !isFieldManagerConflict(lastErr)
+		if !conflict && !isTransient(lastErr) {
+			return false, lastErr
+		}
+		if attempt < attempts {
+			slog.Warn("Retrying Kubernetes API request",
+				slog.String("operation", operation),
+				slog.Int("attempt", attempt),
+				slog.Int("maxAttempts", attempts),
+				slog.Bool("conflict", conflict),
+				slog.String("error", lastErr.Error()))
+		}
+		return false, nil
+	})
+	if err != nil && lastErr != nil && wait.Interrupted(err) {
+		// Attempts used up or the root context was cancelled between attempts.
+		return lastErr
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+var ErrRolloutFailed = errors.New("rollout failed")
+
+const (
+	revisionAnnotation  = "deployment.kubernetes.io/revision"
+	rollbackAnnotation  = "infra-kubeinit/rollback"
+	rolloutPodCheckRate = 5 * time.Second
+)
+
+// failingPodReasons are container waiting reasons that will not resolve on their own.
+var failingPodReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+// rolloutStatus mirrors kubectl rollout status: it returns a progress message,
+// whether the rollout is done, and an error if the rollout can no longer succeed.
+func rolloutStatus(deployment *appsv1.Deployment) (string, bool, error) {
+	if deployment.Generation > deployment.Status.ObservedGeneration {
+		return "waiting for deployment spec update to be observed", false, nil
+	}
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return "", false, fmt.Errorf("%w: deployment %s exceeded its progress deadline: %s", ErrRolloutFailed, deployment.Name, condition.Message)
+		}
+	}
+
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	switch {
+	case status.UpdatedReplicas < replicas:
+		return fmt.Sprintf("%d out of %d new replicas have been updated", status.UpdatedReplicas, replicas), false, nil
+	case status.Replicas > status.UpdatedReplicas:
+		return fmt.Sprintf("%d old replicas are pending termination", status.Replicas-status.UpdatedReplicas), false, nil
+	case status.AvailableReplicas < status.UpdatedReplicas:
+		return fmt.Sprintf("%d of %d updated replicas are available", status.AvailableReplicas, status.UpdatedReplicas), false, nil
+	}
+	return fmt.Sprintf("deployment %s successfully rolled out", deployment.Name), true, nil
+}
+
+// newReplicaSetPods returns the pods of the Deployment's current revision.
+func (k *KubeClient) newReplicaSetPods(ctx context.Context, deployment *appsv1.Deployment) ([]corev1.Pod, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := k.Client.AppsV1().ReplicaSets(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	revision := deployment.Annotations[revisionAnnotation]
+	for _, rs := range replicaSets.Items {
+		if rs.Annotations[revisionAnnotation] != revision || !metav1.IsControlledBy(&rs, deployment) {
+			continue
+		}
+		hash := rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+		podSelector := labels.Merge(deployment.Spec.Selector.MatchLabels, labels.Set{appsv1.DefaultDeploymentUniqueLabelKey: hash})
+		pods, err := k.Client.CoreV1().Pods(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(podSelector).String()})
+		if err != nil {
+			return nil, err
+		}
+		return pods.Items, nil
+	}
+	return nil, nil
+}
+
+// checkRolloutPods fails the rollout when a pod of the new revision is stuck in
+// CrashLoopBackOff, ImagePullBackOff or a similar state.
+func (k *KubeClient) checkRolloutPods(ctx context.Context, deployment *appsv1.Deployment) error {
+	pods, err := k.newReplicaSetPods(ctx, deployment)
+	if err != nil {
+		slog.Warn("Unable to check rollout pods", slog.String("deployment", deployment.Name), slog.String("error", err.Error()))
+		return nil
+	}
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if waiting := status.State.Waiting; waiting != nil && failingPodReasons[waiting.Reason] {
+				return fmt.Errorf("%w: pod %s container %s is in %s: %s", ErrRolloutFailed, pod.Name, status.Name, waiting.Reason, waiting.Message)
+			}
+		}
+	}
+	return nil
+}
+
+// WaitForRollout watches the Deployment until all replicas of the new revision
+// are updated and available, the rollout fails, or timeout elapses.
+func (k *KubeClient) WaitForRollout(namespace string, deploymentName string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(k.Ctx, timeout)
+	defer cancel()
+
+	deploymentsClient := k.Client.AppsV1().Deployments(namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", deploymentName).String()
+	ticker := time.NewTicker(rolloutPodCheckRate)
+	defer ticker.Stop()
+
+	progress := pretty.StartProgress(fmt.Sprintf("Waiting up to %s for deployment %s to roll out", timeout, deploymentName))
+	defer progress.Stop()
+	lastMessage := ""
+	for {
+		deployment, err := deploymentsClient.Get(ctx, deploymentName, metav1.GetOptions{})
+		if err != nil {
+			if cause := k.interrupted(); cause != nil {
+				return fmt.Errorf("stopped waiting for deployment %s rollout %w", deploymentName, cause)
+			}
+			if ctx.Err() != nil {
+				return fmt.Errorf("timed out after %s waiting for deployment %s rollout: %s", timeout, deploymentName, lastMessage)
+			}
+			return fmt.Errorf("error getting deployment %s %w", deploymentName, err)
+		}
+
+		watcher, err := deploymentsClient.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   selector,
+			ResourceVersion: deployment.ResourceVersion,
+		})
+		if err != nil {
+			return fmt.Errorf("error watching deployment %s %w", deploymentName, err)
+		}
+
+		done, err := func() (bool, error) {
+			defer watcher.Stop()
+			for {
+				message, done, err := rolloutStatus(deployment)
+				if err != nil || done {
+					if done {
+						progress.Stop()
+						pretty.Print(message)
+					}
+					return true, err
+				}
+				if message != lastMessage {
+					progress.Update(fmt.Sprintf("Waiting for deployment %s rollout to finish: %s", deploymentName, message))
+					lastMessage = message
+				}
+
+				select {
+				case <-ctx.Done():
+					return false, nil
+				case <-ticker.C:
+					if err := k.checkRolloutPods(ctx, deployment); err != nil {
+						return true, err
+					}
+				case event, ok := <-watcher.ResultChan():
+					if !ok || event.Type == watch.Error {
+						return false, nil
+					}
+					if event.Type == watch.Deleted {
+						return true, fmt.Errorf("%w: deployment %s was deleted", ErrRolloutFailed, deploymentName)
+					}
+					if d, ok := event.Object.(*appsv1.Deployment); ok {
+						deployment = d
+					}
+				}
+			}
+		}()
+		if done {
+			return err
+		}
+		if cause := k.interrupted(); cause != nil {
+			return fmt.Errorf("stopped waiting for deployment %s rollout %w", deploymentName, cause)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %s waiting for deployment %s rollout: %s", timeout, deploymentName, lastMessage)
+		}
+		slog.Info("Deployment watch closed, re-establishing", slog.String("deployment", deploymentName))
+	}
+}
+
+// GetDeployment returns the live Deployment.
+func (k *KubeClient) GetDeployment(namespace string, deploymentName string) (*appsv1.Deployment, error) {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	deployment, err := k.Client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting deployment %s %w", deploymentName, err)
+	}
+	return deployment, nil
+}
+
+// GetDeploymentTemplate returns the current pod template of the Deployment, or
+// nil when the Deployment does not exist yet.
+func (k *KubeClient) GetDeploymentTemplate(namespace string, deploymentName string) (*corev1.PodTemplateSpec, error) {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	deployment, err := k.Client.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting deployment %s %w", deploymentName, err)
+	}
+	return deployment.Spec.Template.DeepCopy(), nil
+}
+
+// previousRevisionTemplate returns the pod template of the newest ReplicaSet
+// revision older than the Deployment's current one.
+func (k *KubeClient) previousRevisionTemplate(ctx context.Context, deployment *appsv1.Deployment) (*corev1.PodTemplateSpec, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := k.Client.AppsV1().ReplicaSets(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	current, _ := strconv.ParseInt(deployment.Annotations[revisionAnnotation], 10, 64)
+	var previous *appsv1.ReplicaSet
+	var previousRevision int64
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if !metav1.IsControlledBy(rs, deployment) {
+			continue
+		}
+		revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err != nil || revision >= current || revision <= previousRevision {
+			continue
+		}
+		previous, previousRevision = rs, revision
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("no previous revision found for deployment %s", deployment.Name)
+	}
+
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	return template, nil
+}
+
+// RollbackDeployment restores the Deployment's pod template to previous, or to
+// the previous ReplicaSet revision when previous is nil. The rollback is recorded
+// in the rollbackAnnotation and waited on like a normal rollout.
+func (k *KubeClient) RollbackDeployment(namespace string, spec *appspec.AppSpec, previous *corev1.PodTemplateSpec, reason string, timeout time.Duration) error {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	deployment, err := k.Client.AppsV1().Deployments(namespace).Get(ctx, spec.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error getting deployment %s %w", spec.Name, err)
+	}
+	if previous == nil {
+		previous, err = k.previousRevisionTemplate(ctx, deployment)
+		if err != nil {
+			return fmt.Errorf("unable to find a template to roll back to %w", err)
+		}
+	}
+
+	desired := spec.Deployment(namespace)
+	desired.Spec.Template = *previous
+	if desired.Annotations == nil {
+		desired.Annotations = make(map[string]string)
+	}
+	desired.Annotations[rollbackAnnotation] = fmt.Sprintf("%s rolled back from revision %s: %s",
+		time.Now().UTC().Format(time.RFC3339), deployment.Annotations[revisionAnnotation], reason)
+
+	if k.clientDryRun("apply", desired) {
+		return nil
+	}
+	applyConfig, err := deploymentApplyConfiguration(desired)
+	if err != nil {
+		return err
+	}
+
+	// Force is required: the fields being restored were just applied by us with other values.
+	err = k.retry("roll back Deployment "+spec.Name, func(ctx context.Context) error {
+		_, err := k.Client.AppsV1().Deployments(namespace).Apply(ctx, applyConfig, k.applyOptions(ApplyOptions{Force: true}))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to roll back deployment %s %w", spec.Name, err)
+	}
+	pretty.PrintWarningf("Rolled back deployment %s from revision %s", spec.Name, deployment.Annotations[revisionAnnotation])
+	slog.Warn("Deployment rolled back", slog.String("deployment", spec.Name), slog.String("reason", reason))
+	if k.dryRunning() {
+		return nil
+	}
+
+	return k.WaitForRollout(namespace, spec.Name, timeout)
+}
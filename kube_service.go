@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const loadBalancerPollInterval = 2 * time.Second
+
+// CreateOrUpdateService creates the Service, or updates the existing one in place.
+// Fields allocated by the cluster (clusterIP, node ports, health check node port)
+// are carried over from the live Service so the update is accepted and stable.
+func (k *KubeClient) CreateOrUpdateService(desired *corev1.Service) error {
+	servicesClient := k.Client.CoreV1().Services(desired.Namespace)
+	// Re-read on every attempt so conflicting updates are resolved against the latest version.
+	return k.retry("create or update Service "+desired.Name, func(ctx context.Context) error {
+		existing, err := servicesClient.Get(ctx, desired.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			if k.clientDryRun("create", desired) {
+				return nil
+			}
+			_, err = servicesClient.Create(ctx, desired, k.createOptions())
+			if err != nil {
+				return fmt.Errorf("failed to create Service %s: %w", desired.Name, err)
+			}
+			slog.Info("Service created successfully", slog.String("serviceName", desired.Name), slog.String("type", string(desired.Spec.Type)))
+			return nil
+		}
+		if err != nil {
+			slog.Error("error performing get for service", slog.String("error", err.Error()), slog.String("serviceName", desired.Name))
+			return fmt.Errorf("failed to get Service %s: %w", desired.Name, err)
+		}
+
+		updated := existing.DeepCopy()
+		if updated.Labels == nil {
+			updated.Labels = make(map[string]string)
+		}
+		maps.Copy(updated.Labels, desired.Labels)
+		if updated.Annotations == nil && len(desired.Annotations) > 0 {
+			updated.Annotations = make(map[string]string)
+		}
+		maps.Copy(updated.Annotations, desired.Annotations)
+
+		spec := desired.Spec.DeepCopy()
+		preserveAllocatedFields(spec, &existing.Spec)
+		updated.Spec = *spec
+
+		if k.clientDryRun("update", updated) {
+			return nil
+		}
+		_, err = servicesClient.Update(ctx, updated, k.updateOptions())
+		if err != nil {
+			return fmt.Errorf("failed to update Service %s: %w", desired.Name, err)
+		}
+		slog.Info("Service updated successfully", slog.String("serviceName", desired.Name), slog.String("type", string(desired.Spec.Type)))
+		return nil
+	})
+}
+
+// preserveAllocatedFields copies cluster-assigned values from the live Service
+// spec into the desired one. Node ports are matched by port name, then by port number.
+func preserveAllocatedFields(desired *corev1.ServiceSpec, live *corev1.ServiceSpec) {
+	if desired.ClusterIP == "" {
+		desired.ClusterIP = live.ClusterIP
+		desired.ClusterIPs = live.ClusterIPs
+		desired.IPFamilies = live.IPFamilies
+		desired.IPFamilyPolicy = live.IPFamilyPolicy
+	}
+	if desired.HealthCheckNodePort == 0 && desired.Type == live.Type {
+		desired.HealthCheckNodePort = live.HealthCheckNodePort
+	}
+	if desired.Type == corev1.ServiceTypeClusterIP {
+		return
+	}
+
+	for i := range desired.Ports {
+		port := &desired.Ports[i]
+		if port.NodePort != 0 {
+			continue
+		}
+		for _, livePort := range live.Ports {
+			if livePort.Name == port.Name && livePort.Protocol == port.Protocol {
+				port.NodePort = livePort.NodePort
+				break
+			}
+		}
+		if port.NodePort != 0 {
+			continue
+		}
+		for _, livePort := range live.Ports {
+			if livePort.Port == port.Port && livePort.Protocol == port.Protocol {
+				port.NodePort = livePort.NodePort
+				break
+			}
+		}
+	}
+}
+
+// WaitForLoadBalancerIngress waits for the LoadBalancer to publish its ingress
+// addresses and returns them.
+func (k *KubeClient) WaitForLoadBalancerIngress(namespace string, serviceName string, timeout time.Duration) ([]string, error) {
+	progress := pretty.StartProgress(fmt.Sprintf("Waiting up to %s for Service %s external address", timeout, serviceName))
+	defer progress.Stop()
+
+	var addresses []string
+	err := wait.PollUntilContextTimeout(k.Ctx, loadBalancerPollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		service, err := k.Client.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP != "" {
+				addresses = append(addresses, ingress.IP)
+			} else if ingress.Hostname != "" {
+				addresses = append(addresses, ingress.Hostname)
+			}
+		}
+		return len(addresses) > 0, nil
+	})
+	if cause := k.interrupted(); cause != nil {
+		return nil, fmt.Errorf("stopped waiting for Service %s load balancer ingress %w", serviceName, cause)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load balancer ingress for Service %s not ready after %s: %w", serviceName, timeout, err)
+	}
+
+	progress.Stop()
+	pretty.Printf("Service %s external address: %v", serviceName, addresses)
+	return addresses, nil
+}
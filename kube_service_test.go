@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestService(serviceType corev1.ServiceType) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "go-infra",
+			Namespace: "default",
+			Labels:    map[string]string{"app": "go-infra"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     serviceType,
+			Selector: map[string]string{"app": "go-infra"},
+			Ports: []corev1.ServicePort{{
+				Name:       "http",
+				Protocol:   corev1.ProtocolTCP,
+				Port:       80,
+				TargetPort: intstr.FromInt32(8993),
+			}},
+		},
+	}
+}
+
+func TestPreserveAllocatedFields(t *testing.T) {
+	live := newTestService(corev1.ServiceTypeLoadBalancer).Spec
+	live.ClusterIP = "10.96.0.10"
+	live.ClusterIPs = []string{"10.96.0.10"}
+	live.HealthCheckNodePort = 31000
+	live.Ports[0].NodePort = 30080
+
+	t.Run("same type keeps allocations", func(t *testing.T) {
+		desired := newTestService(corev1.ServiceTypeLoadBalancer).Spec
+		preserveAllocatedFields(&desired, &live)
+		if desired.ClusterIP != live.ClusterIP {
+			t.Errorf("ClusterIP = %q, want %q", desired.ClusterIP, live.ClusterIP)
+		}
+		if desired.HealthCheckNodePort != live.HealthCheckNodePort {
+			t.Errorf("HealthCheckNodePort = %d, want %d", desired.HealthCheckNodePort, live.HealthCheckNodePort)
+		}
+		if desired.Ports[0].NodePort != 30080 {
+			t.Errorf("NodePort = %d, want 30080", desired.Ports[0].NodePort)
+		}
+	})
+
+	t.Run("renamed port matches by number", func(t *testing.T) {
+		desired := newTestService(corev1.ServiceTypeLoadBalancer).Spec
+		desired.Ports[0].Name = "web"
+		preserveAllocatedFields(&desired, &live)
+		if desired.Ports[0].NodePort != 30080 {
+			t.Errorf("NodePort = %d, want 30080", desired.Ports[0].NodePort)
+		}
+	})
+
+	t.Run("switch to ClusterIP drops node ports", func(t *testing.T) {
+		desired := newTestService(corev1.ServiceTypeClusterIP).Spec
+		preserveAllocatedFields(&desired, &live)
+		if desired.ClusterIP != live.ClusterIP {
+			t.Errorf("ClusterIP = %q, want %q", desired.ClusterIP, live.ClusterIP)
+		}
+		if desired.HealthCheckNodePort != 0 || desired.Ports[0].NodePort != 0 {
+			t.Errorf("node ports carried over to a ClusterIP service: %+v", desired)
+		}
+	})
+}
+
+func TestCreateOrUpdateService(t *testing.T) {
+	t.Run("creates a missing service", func(t *testing.T) {
+		k, clientset := newFakeKubeClient(t)
+		if err := k.CreateOrUpdateService(newTestService(corev1.ServiceTypeLoadBalancer)); err != nil {
+			t.Fatalf("CreateOrUpdateService() error = %v", err)
+		}
+		if _, err := clientset.CoreV1().Services("default").Get(context.Background(), "go-infra", metav1.GetOptions{}); err != nil {
+			t.Fatalf("service was not created: %v", err)
+		}
+	})
+
+	t.Run("updates in place and retries conflicts", func(t *testing.T) {
+		live := newTestService(corev1.ServiceTypeLoadBalancer)
+		live.Labels["team"] = "infra"
+		live.Spec.ClusterIP = "10.96.0.10"
+		live.Spec.Ports[0].NodePort = 30080
+		k, clientset := newFakeKubeClient(t, live)
+
+		updates := 0
+		clientset.PrependReactor("update", "services", func(k8stesting.Action) (bool, runtime.Object, error) {
+			updates++
+			if updates == 1 {
+				return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "services"}, "go-infra", nil)
+			}
+			return false, nil, nil
+		})
+
+		desired := newTestService(corev1.ServiceTypeLoadBalancer)
+		desired.Spec.Ports[0].TargetPort = intstr.FromInt32(9000)
+		if err := k.CreateOrUpdateService(desired); err != nil {
+			t.Fatalf("CreateOrUpdateService() error = %v", err)
+		}
+		if updates != 2 {
+			t.Errorf("update called %d times, want 2", updates)
+		}
+
+		got, err := clientset.CoreV1().Services("default").Get(context.Background(), "go-infra", metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Spec.ClusterIP != "10.96.0.10" || got.Spec.Ports[0].NodePort != 30080 {
+			t.Errorf("allocated fields not preserved: clusterIP %q, nodePort %d", got.Spec.ClusterIP, got.Spec.Ports[0].NodePort)
+		}
+		if got.Spec.Ports[0].TargetPort.IntVal != 9000 {
+			t.Errorf("targetPort = %s, want 9000", got.Spec.Ports[0].TargetPort.String())
+		}
+		if got.Labels["team"] != "infra" {
+			t.Errorf("existing label dropped: %v", got.Labels)
+		}
+	})
+
+	t.Run("client dry-run does not write", func(t *testing.T) {
+		k, clientset := newFakeKubeClient(t)
+		k.DryRun = DryRunClient
+		if err := k.CreateOrUpdateService(newTestService(corev1.ServiceTypeLoadBalancer)); err != nil {
+			t.Fatalf("CreateOrUpdateService() error = %v", err)
+		}
+		for _, action := range clientset.Actions() {
+			if action.GetVerb() != "get" {
+				t.Errorf("unexpected %s request in client dry-run", action.GetVerb())
+			}
+		}
+	})
+}
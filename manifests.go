@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// manifestExtensions are the file types read when a directory is given.
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// kindOrder is the order objects are applied in, so that namespaces, identities
+// and configuration exist before the workloads that reference them.
+var kindOrder = map[string]int{
+	"Namespace":                0,
+	"CustomResourceDefinition": 1,
+	"ServiceAccount":           2,
+	"ClusterRole":              3,
+	"Role":                     4,
+	"ClusterRoleBinding":       5,
+	"RoleBinding":              6,
+	"Secret":                   7,
+	"ConfigMap":                8,
+	"PersistentVolumeClaim":    9,
+	"Service":                  10,
+	"Deployment":               11,
+	"StatefulSet":              11,
+	"DaemonSet":                11,
+	"Job":                      11,
+	"CronJob":                  11,
+}
+
+const otherKindsOrder = 12
+
+// manifestFiles expands the given files and directories into the manifest files
+// they contain. Directories are walked recursively in lexical order.
+func manifestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && manifestExtensions[strings.ToLower(filepath.Ext(p))] {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading directory %s %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// decodeManifests decodes every YAML document or JSON object in r. Empty
+// documents are skipped and List kinds are expanded into their items.
+func decodeManifests(r io.Reader, source string) ([]*unstructured.Unstructured, error) {
+	var objects []*unstructured.Unstructured
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for i := 0; ; i++ {
+		content := map[string]any{}
+		if err := decoder.Decode(&content); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objects, nil
+			}
+			return nil, fmt.Errorf("error decoding document %d of %s %w", i, source, err)
+		}
+		if len(content) == 0 {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("document %d of %s has no apiVersion or kind", i, source)
+		}
+		if obj.IsList() {
+			err := obj.EachListItem(func(item runtime.Object) error {
+				objects = append(objects, item.(*unstructured.Unstructured))
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error reading list in %s %w", source, err)
+			}
+			continue
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// LoadManifests reads all objects from the given files and directories and sorts
+// them into apply order.
+func LoadManifests(paths []string) ([]*unstructured.Unstructured, error) {
+	files, err := manifestFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []*unstructured.Unstructured
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeManifests(f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+
+	sortForApply(objects)
+	return objects, nil
+}
+
+func sortForApply(objects []*unstructured.Unstructured) {
+	order := func(obj *unstructured.Unstructured) int {
+		if o, ok := kindOrder[obj.GetKind()]; ok {
+			return o
+		}
+		return otherKindsOrder
+	}
+	sort.SliceStable(objects, func(i, j int) bool {
+		return order(objects[i]) < order(objects[j])
+	})
+}
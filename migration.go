@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func getLatestSuccessfulJob(jobsList []batchv1.Job) *batchv1.Job {
+	var latestJob *batchv1.Job
+	var latestCompletionTime time.Time
+
+	for _, job := range jobsList {
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchv1.JobComplete && condition.Status == "True" {
+				if condition.LastTransitionTime.Time.After(latestCompletionTime) {
+					latestCompletionTime = condition.LastTransitionTime.Time
+					latestJob = &job
+				}
+			}
+		}
+	}
+	return latestJob
+}
+
+// MigrationOptions controls how the database migration Job is run.
+type MigrationOptions struct {
+	Spec       *appspec.AppSpec
+	Namespace  string
+	Image      string
+	Version    string
+	Timeout    time.Duration
+	FollowLogs bool
+	TailLines  int64
+	// RerunAfter forces a new migration when the last successful one is older
+	// than this, even if the image is unchanged. Zero disables the time window.
+	RerunAfter time.Duration
+}
+
+// MigrationDecision records whether a migration Job needs to run and why.
+type MigrationDecision struct {
+	Run       bool
+	Reason    string
+	LatestJob *batchv1.Job
+}
+
+// jobImage returns the migration image recorded on the Job, falling back to the
+// container image for Jobs created before the annotation existed.
+func jobImage(job *batchv1.Job) string {
+	if image, ok := job.Annotations[annotationImage]; ok {
+		return image
+	}
+	if containers := job.Spec.Template.Spec.Containers; len(containers) > 0 {
+		return containers[0].Image
+	}
+	return ""
+}
+
+// imageDigest returns the sha256 digest part of an image reference or image ID.
+func imageDigest(image string) string {
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return digest
+	}
+	if strings.HasPrefix(image, "sha256:") {
+		return image
+	}
+	return ""
+}
+
+// decideMigration compares the requested image to the image of the latest
+// successful migration Job. When the requested image is pinned by digest, the
+// digest recorded on the Job is compared instead of the reference string.
+func decideMigration(jobs []batchv1.Job, opts MigrationOptions, now time.Time) MigrationDecision {
+	latestJob := getLatestSuccessfulJob(jobs)
+	if latestJob == nil {
+		return MigrationDecision{Run: true, Reason: "no successful migration jobs found"}
+	}
+
+	decision := MigrationDecision{LatestJob: latestJob}
+	lastImage := jobImage(latestJob)
+	if wantDigest := imageDigest(opts.Image); wantDigest != "" {
+		lastDigest := latestJob.Annotations[annotationImageDigest]
+		if lastDigest == "" {
+			lastDigest = imageDigest(lastImage)
+		}
+		if lastDigest != wantDigest {
+			decision.Run = true
+			decision.Reason = fmt.Sprintf("image digest changed from %q to %q since job %s", lastDigest, wantDigest, latestJob.Name)
+			return decision
+		}
+	} else if lastImage != opts.Image {
+		decision.Run = true
+		decision.Reason = fmt.Sprintf("image changed from %q to %q since job %s", lastImage, opts.Image, latestJob.Name)
+		return decision
+	}
+
+	completionTime := latestJob.Status.CompletionTime
+	if opts.RerunAfter > 0 {
+		if completionTime == nil {
+			decision.Run = true
+			decision.Reason = fmt.Sprintf("job %s has no completion time and -migration-rerun-after is set", latestJob.Name)
+			return decision
+		}
+		if age := now.Sub(completionTime.Time); age > opts.RerunAfter {
+			decision.Run = true
+			decision.Reason = fmt.Sprintf("image %q unchanged but job %s completed %s ago, more than %s", opts.Image, latestJob.Name, age.Round(time.Second), opts.RerunAfter)
+			return decision
+		}
+	}
+
+	decision.Reason = fmt.Sprintf("image %q was already migrated by job %s", opts.Image, latestJob.Name)
+	if completionTime != nil {
+		decision.Reason = fmt.Sprintf("%s at %s", decision.Reason, formatTime(completionTime))
+	}
+	return decision
+}
+
+// Migration job states reported in MigrationResult.Status.
+const (
+	migrationSucceeded = "succeeded"
+	migrationFailed    = "failed"
+	migrationDryRun    = "dry-run"
+)
+
+// MigrationResult is the migration history PrepDeployment found, its decision
+// and the outcome of the Job it ran.
+type MigrationResult struct {
+	App       string       `json:"app"`
+	Namespace string       `json:"namespace"`
+	Image     string       `json:"image,omitempty"`
+	History   []JobSummary `json:"history"`
+	Run       bool         `json:"run"`
+	Reason    string       `json:"reason"`
+	Job       string       `json:"job,omitempty"`
+	Status    string       `json:"status,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+func (r *MigrationResult) printTable() {
+	if len(r.History) > 0 {
+		history := slices.Clone(r.History)
+		sortJobHistory(history)
+		printJobHistory(history)
+	}
+	switch {
+	case r.Status == migrationFailed:
+		pretty.PrintErrorf("Migration job %s failed: %s", orNone(r.Job), r.Error)
+	case !r.Run:
+		pretty.Printf("Migration skipped: %s", r.Reason)
+	default:
+		pretty.Printf("Migration job %s %s: %s", r.Job, r.Status, r.Reason)
+	}
+}
+
+// formatTime returns t in RFC 3339, or <none> when unset.
+func formatTime(t *metav1.Time) string {
+	if t == nil {
+		return "<none>"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// PrepDeployment runs the database migration Job when decideMigration says it
+// is needed and waits for it. The result is returned on failure as well.
+func (k *KubeClient) PrepDeployment(opts MigrationOptions) (*MigrationResult, error) {
+	result := &MigrationResult{App: opts.Spec.Name, Namespace: opts.Namespace, Image: opts.Image, History: []JobSummary{}}
+	if opts.Spec.Migration == nil {
+		result.Reason = fmt.Sprintf("app spec %s has no migration job", opts.Spec.Name)
+		pretty.Printf("App spec %s has no migration job, skipping migration", opts.Spec.Name)
+		return result, nil
+	}
+
+	// Retrieve the app's migration jobs
+	jobsList, err := k.GetBatchJobByLabel(opts.Namespace, MigrationHistorySelector(opts.Spec.Name, ""))
+	if err != nil {
+		err = fmt.Errorf("error retrieving batch jobs %w", err)
+		result.Status, result.Error = migrationFailed, err.Error()
+		return result, err
+	}
+	for i := range jobsList.Items {
+		result.History = append(result.History, summarizeJob(&jobsList.Items[i]))
+	}
+
+	decision := decideMigration(jobsList.Items, opts, time.Now())
+	result.Run, result.Reason = decision.Run, decision.Reason
+	if !decision.Run {
+		pretty.Printf("Skipping migration: %s", decision.Reason)
+		return result, nil
+	}
+	pretty.PrintWarningf("Creating migration job: %s", decision.Reason)
+	result.Job, err = k.runMigrationJob(opts)
+	switch {
+	case err != nil:
+		result.Status, result.Error = migrationFailed, err.Error()
+	case k.dryRunning():
+		result.Status = migrationDryRun
+	default:
+		result.Status = migrationSucceeded
+	}
+	return result, err
+}
+
+// runMigrationJob creates the database migration Job, blocks until it finishes
+// and returns its name. When the Job fails, the tail of the failing pod's logs
+// is included in the error.
+func (k *KubeClient) runMigrationJob(opts MigrationOptions) (string, error) {
+	jobName := migrationJobName(opts.Image)
+	labels, annotations := migrationJobMetadata(opts.Spec.Name, opts.Image, opts.Version)
+	err := k.CreateBatchJob(jobName, opts.Namespace, opts.Spec, labels, annotations)
+	if err != nil {
+		return "", fmt.Errorf("error creating database migration job %w", err)
+	}
+	if k.dryRunning() {
+		pretty.Printf("Dry run: not waiting for migration job %s", jobName)
+		return jobName, nil
+	}
+
+	var wg sync.WaitGroup
+	logCtx, stopLogs := context.WithCancel(k.Ctx)
+	if opts.FollowLogs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.StreamJobLogs(logCtx, opts.Namespace, jobName)
+		}()
+	}
+
+	err = k.WaitForJobCompletion(opts.Namespace, jobName, opts.Timeout)
+	stopLogs()
+	wg.Wait()
+	if err != nil {
+		if opts.TailLines > 0 {
+			logs, logErr := k.TailJobLogs(opts.Namespace, jobName, opts.TailLines)
+			if logErr != nil {
+				slog.Error("Error retrieving migration job logs", slog.String("error", logErr.Error()))
+			} else {
+				return jobName, fmt.Errorf("database migration job did not succeed %w\n%s", err, logs)
+			}
+		}
+		return jobName, fmt.Errorf("database migration job did not succeed %w", err)
+	}
+	pretty.Printf("Migration job %s completed successfully", jobName)
+
+	if err := k.RecordJobImageDigest(opts.Namespace, jobName); err != nil {
+		slog.Warn("Unable to record migration image digest", slog.String("job", jobName), slog.String("error", err.Error()))
+	}
+	return jobName, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	oldImage = "ghcr.io/babbage88/init-infradb:v1.2.1"
+	newImage = "ghcr.io/babbage88/init-infradb:v1.2.2"
+)
+
+func TestGetLatestSuccessfulJob(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		jobs []batchv1.Job
+		want string
+	}{
+		{name: "no jobs"},
+		{
+			name: "only failed jobs",
+			jobs: []batchv1.Job{newMigrationJob("failed", oldImage, batchv1.JobFailed, now)},
+		},
+		{
+			name: "latest completion wins",
+			jobs: []batchv1.Job{
+				newMigrationJob("older", oldImage, batchv1.JobComplete, now.Add(-time.Hour)),
+				newMigrationJob("newer", newImage, batchv1.JobComplete, now),
+				newMigrationJob("oldest", oldImage, batchv1.JobComplete, now.Add(-2*time.Hour)),
+			},
+			want: "newer",
+		},
+		{
+			name: "newer failure is ignored",
+			jobs: []batchv1.Job{
+				newMigrationJob("succeeded", oldImage, batchv1.JobComplete, now.Add(-time.Hour)),
+				newMigrationJob("failed", newImage, batchv1.JobFailed, now),
+			},
+			want: "succeeded",
+		},
+		{
+			name: "condition not true",
+			jobs: func() []batchv1.Job {
+				job := newMigrationJob("pending", oldImage, batchv1.JobComplete, now)
+				job.Status.Conditions[0].Status = corev1.ConditionFalse
+				return []batchv1.Job{job}
+			}(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getLatestSuccessfulJob(tt.jobs)
+			switch {
+			case tt.want == "" && got != nil:
+				t.Errorf("got job %s, want none", got.Name)
+			case tt.want != "" && got == nil:
+				t.Errorf("got no job, want %s", tt.want)
+			case tt.want != "" && got.Name != tt.want:
+				t.Errorf("got job %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecideMigration(t *testing.T) {
+	now := time.Now()
+	const digest = "sha256:0123456789abcdef"
+	withDigest := func(job batchv1.Job, digest string) batchv1.Job {
+		job.Annotations[annotationImageDigest] = digest
+		return job
+	}
+
+	tests := []struct {
+		name       string
+		jobs       []batchv1.Job
+		image      string
+		rerunAfter time.Duration
+		wantRun    bool
+	}{
+		{name: "no history", image: newImage, wantRun: true},
+		{
+			name:    "image unchanged",
+			jobs:    []batchv1.Job{newMigrationJob("done", newImage, batchv1.JobComplete, now)},
+			image:   newImage,
+			wantRun: false,
+		},
+		{
+			name:    "image changed",
+			jobs:    []batchv1.Job{newMigrationJob("done", oldImage, batchv1.JobComplete, now)},
+			image:   newImage,
+			wantRun: true,
+		},
+		{
+			name:    "digest unchanged under a new tag",
+			jobs:    []batchv1.Job{withDigest(newMigrationJob("done", oldImage, batchv1.JobComplete, now), digest)},
+			image:   newImage + "@" + digest,
+			wantRun: false,
+		},
+		{
+			name:    "digest changed",
+			jobs:    []batchv1.Job{withDigest(newMigrationJob("done", newImage, batchv1.JobComplete, now), "sha256:fedcba")},
+			image:   newImage + "@" + digest,
+			wantRun: true,
+		},
+		{
+			name:       "rerun window elapsed",
+			jobs:       []batchv1.Job{newMigrationJob("done", newImage, batchv1.JobComplete, now.Add(-2*time.Hour))},
+			image:      newImage,
+			rerunAfter: time.Hour,
+			wantRun:    true,
+		},
+		{
+			name:       "inside rerun window",
+			jobs:       []batchv1.Job{newMigrationJob("done", newImage, batchv1.JobComplete, now.Add(-time.Minute))},
+			image:      newImage,
+			rerunAfter: time.Hour,
+			wantRun:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := decideMigration(tt.jobs, MigrationOptions{Image: tt.image, RerunAfter: tt.rerunAfter}, now)
+			if decision.Run != tt.wantRun {
+				t.Errorf("Run = %v, want %v (reason: %s)", decision.Run, tt.wantRun, decision.Reason)
+			}
+			if decision.Reason == "" {
+				t.Error("decision has no reason")
+			}
+		})
+	}
+}
+
+// completeCreatedJobs makes created Jobs finish immediately, standing in for the
+// Job controller the fake clientset does not run.
+func completeCreatedJobs(action k8stesting.Action) (bool, runtime.Object, error) {
+	job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+	now := metav1.Now()
+	job.Status.CompletionTime = &now
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: now}}
+	return false, nil, nil
+}
+
+func createdJobs(actions []k8stesting.Action) []*batchv1.Job {
+	var jobs []*batchv1.Job
+	for _, action := range actions {
+		if create, ok := action.(k8stesting.CreateAction); ok && action.GetResource().Resource == "jobs" {
+			jobs = append(jobs, create.GetObject().(*batchv1.Job))
+		}
+	}
+	return jobs
+}
+
+func TestPrepDeployment(t *testing.T) {
+	jobsResource := schema.GroupResource{Group: "batch", Resource: "jobs"}
+	staged := func(job batchv1.Job) *batchv1.Job {
+		job.Namespace = "staging"
+		return &job
+	}
+	// billing shares the namespace and migrated a different image more recently.
+	billing := func() *batchv1.Job {
+		job := newMigrationJob("init-db-billing-abcde", "ghcr.io/babbage88/billing-migrate:v1", batchv1.JobComplete, time.Now())
+		job.Labels, job.Annotations = migrationJobMetadata("billing", "ghcr.io/babbage88/billing-migrate:v1", "")
+		return staged(job)
+	}
+
+	tests := []struct {
+		name        string
+		noMigration bool
+		existing    []runtime.Object
+		reactors    map[string]k8stesting.ReactionFunc
+		wantHistory int
+		wantCreated int
+		wantStatus  string
+		wantErr     string
+	}{
+		{
+			name:        "spec without migration",
+			noMigration: true,
+		},
+		{
+			name:        "image already migrated",
+			existing:    []runtime.Object{staged(newMigrationJob("init-db-v1-2-2-abcde", newImage, batchv1.JobComplete, time.Now()))},
+			wantHistory: 1,
+		},
+		{
+			name: "history of another app in the namespace is ignored",
+			existing: []runtime.Object{
+				staged(newMigrationJob("init-db-v1-2-2-abcde", newImage, batchv1.JobComplete, time.Now().Add(-time.Hour))),
+				billing(),
+			},
+			wantHistory: 1,
+		},
+		{
+			name:        "only another app has migrated",
+			existing:    []runtime.Object{billing()},
+			reactors:    map[string]k8stesting.ReactionFunc{"create": completeCreatedJobs},
+			wantCreated: 1,
+			wantStatus:  migrationSucceeded,
+		},
+		{
+			name: "history in another namespace is ignored",
+			existing: []runtime.Object{func() *batchv1.Job {
+				j := newMigrationJob("prod", newImage, batchv1.JobComplete, time.Now())
+				return &j
+			}()},
+			reactors:    map[string]k8stesting.ReactionFunc{"create": completeCreatedJobs},
+			wantCreated: 1,
+			wantStatus:  migrationSucceeded,
+		},
+		{
+			name:        "image changed",
+			existing:    []runtime.Object{staged(newMigrationJob("init-db-v1-2-1-abcde", oldImage, batchv1.JobComplete, time.Now()))},
+			reactors:    map[string]k8stesting.ReactionFunc{"create": completeCreatedJobs},
+			wantHistory: 1,
+			wantCreated: 1,
+			wantStatus:  migrationSucceeded,
+		},
+		{
+			name: "listing jobs fails",
+			reactors: map[string]k8stesting.ReactionFunc{"list": func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewForbidden(jobsResource, "", errors.New("no list"))
+			}},
+			wantStatus: migrationFailed,
+			wantErr:    "error retrieving batch jobs",
+		},
+		{
+			name: "creating the job keeps failing",
+			reactors: map[string]k8stesting.ReactionFunc{"create": func(k8stesting.Action) (bool, runtime.Object, error) {
+				return true, nil, apierrors.NewServiceUnavailable("apiserver restarting")
+			}},
+			wantCreated: 3,
+			wantStatus:  migrationFailed,
+			wantErr:     "error creating database migration job",
+		},
+		{
+			name: "job fails",
+			reactors: map[string]k8stesting.ReactionFunc{"create": func(action k8stesting.Action) (bool, runtime.Object, error) {
+				job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+				job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"}}
+				return false, nil, nil
+			}},
+			wantCreated: 1,
+			wantStatus:  migrationFailed,
+			wantErr:     "BackoffLimitExceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, clientset := newFakeKubeClient(t, tt.existing...)
+			for verb, reactor := range tt.reactors {
+				clientset.PrependReactor(verb, "jobs", reactor)
+			}
+			spec := appspec.Default("go-infra", "ghcr.io/babbage88/go-infra:v1.2.2", newImage, 8993, 1)
+			if tt.noMigration {
+				spec.Migration = nil
+			}
+
+			result, err := k.PrepDeployment(MigrationOptions{
+				Spec:      spec,
+				Namespace: "staging",
+				Image:     newImage,
+				Version:   "v1.2.2",
+				Timeout:   5 * time.Second,
+			})
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("PrepDeployment() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("PrepDeployment() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if result.Status != tt.wantStatus {
+				t.Errorf("result status = %q, want %q", result.Status, tt.wantStatus)
+			}
+			if result.Run != (tt.wantCreated > 0) {
+				t.Errorf("result run = %v, want %v (reason: %s)", result.Run, tt.wantCreated > 0, result.Reason)
+			}
+			if len(result.History) != tt.wantHistory {
+				t.Errorf("result has %d jobs in history, want %d", len(result.History), tt.wantHistory)
+			}
+
+			created := createdJobs(clientset.Actions())
+			if len(created) != tt.wantCreated {
+				t.Fatalf("created %d jobs, want %d", len(created), tt.wantCreated)
+			}
+			for _, job := range created {
+				if job.Namespace != "staging" {
+					t.Errorf("job created in namespace %q, want staging", job.Namespace)
+				}
+				if job.Annotations[annotationImage] != newImage {
+					t.Errorf("job image annotation = %q, want %q", job.Annotations[annotationImage], newImage)
+				}
+				if job.Labels[labelReleaseVersion] != "v1-2-2" {
+					t.Errorf("job release label = %q, want v1-2-2", job.Labels[labelReleaseVersion])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/babbage88/infra-kubeinit/internal/pretty"
+	"sigs.k8s.io/yaml"
+)
+
+// OutputFormat selects how a command prints its result.
+type OutputFormat string
+
+const (
+	// OutputTable prints progress and a human-readable summary on stdout.
+	OutputTable OutputFormat = "table"
+	// OutputJSON prints only the result as JSON on stdout, progress goes to stderr.
+	OutputJSON OutputFormat = "json"
+	// OutputYAML prints only the result as YAML on stdout, progress goes to stderr.
+	OutputYAML OutputFormat = "yaml"
+)
+
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch OutputFormat(s) {
+	case "", "text", OutputTable:
+		return OutputTable, nil
+	case OutputJSON, OutputYAML:
+		return OutputFormat(s), nil
+	}
+	return OutputTable, fmt.Errorf("unknown output format %q, must be table, json or yaml", s)
+}
+
+// result is the typed outcome of a command. printTable renders it for humans
+// through the pretty printer; json and yaml render its exported fields.
+type result interface {
+	printTable()
+}
+
+// applyOutput sets up the printer shared by progress messages and slog records
+// at the -log-level. It writes to stderr when stdout carries a structured result.
+func (g *globalOptions) applyOutput() {
+	w := os.Stdout
+	if g.format != OutputTable {
+		w = os.Stderr
+	}
+	printer := pretty.New(w, pretty.WithLevel(g.level))
+	pretty.SetDefault(printer)
+	slog.SetDefault(slog.New(pretty.NewHandler(printer)))
+}
+
+// printResult writes r to stdout in the selected output format.
+func (g *globalOptions) printResult(r result) {
+	if err := writeResult(g.stdout, g.format, r); err != nil {
+		pretty.PrintErrorf("Error printing result: %s", err.Error())
+	}
+}
+
+func writeResult(w io.Writer, format OutputFormat, r result) error {
+	var data []byte
+	var err error
+	switch format {
+	case OutputJSON:
+		data, err = json.MarshalIndent(r, "", "  ")
+		data = append(data, '\n')
+	case OutputYAML:
+		data, err = yaml.Marshal(r)
+	default:
+		r.printTable()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// formatTable aligns rows under header and returns the lines, header first.
+func formatTable(header []string, rows [][]string) []string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// printTable prints rows under header.
+func printTable(header []string, rows [][]string) {
+	for _, line := range formatTable(header, rows) {
+		pretty.Printf("%s", line)
+	}
+}
+
+// orNone returns s, or <none> when s is empty.
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
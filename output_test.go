@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	for input, want := range map[string]OutputFormat{"": OutputTable, "text": OutputTable, "table": OutputTable, "json": OutputJSON, "yaml": OutputYAML} {
+		got, err := ParseOutputFormat(input)
+		if err != nil || got != want {
+			t.Errorf("ParseOutputFormat(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	if _, err := ParseOutputFormat("wide"); err == nil {
+		t.Error("ParseOutputFormat(wide) returned no error")
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	result := &deployResult{
+		App:       "go-infra",
+		Namespace: "staging",
+		DryRun:    DryRunNone,
+		Migration: &MigrationResult{App: "go-infra", Namespace: "staging", History: []JobSummary{}, Reason: "image unchanged"},
+		Error:     "preflight checks failed",
+	}
+
+	var buf bytes.Buffer
+	if err := writeResult(&buf, OutputJSON, result); err != nil {
+		t.Fatalf("writeResult(json) error = %v", err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	for _, key := range []string{"app", "namespace", "dryRun", "migration", "error"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("JSON output has no %q key: %s", key, buf.String())
+		}
+	}
+	for _, key := range []string{"preflight", "deployment", "service"} {
+		if _, ok := decoded[key]; ok {
+			t.Errorf("JSON output has %q for a step that did not run", key)
+		}
+	}
+
+	buf.Reset()
+	if err := writeResult(&buf, OutputYAML, &bumpResult{Previous: "v1.2.2", Version: "v1.2.3"}); err != nil {
+		t.Fatalf("writeResult(yaml) error = %v", err)
+	}
+	if got, want := buf.String(), "previous: v1.2.2\nversion: v1.2.3\n"; got != want {
+		t.Errorf("YAML output = %q, want %q", got, want)
+	}
+}
+
+func TestFormatTable(t *testing.T) {
+	lines := formatTable([]string{"NAME", "STATUS"}, [][]string{{"init-db-v1-2-2", "Complete"}, {"a", "Failed"}})
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3", len(lines))
+	}
+	column := strings.Index(lines[0], "STATUS")
+	for _, line := range lines[1:] {
+		if strings.Index(line, strings.Fields(line)[1]) != column {
+			t.Errorf("column not aligned: %q", line)
+		}
+	}
+}
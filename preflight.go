@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// minServerVersion is the oldest apiserver with GA server-side apply.
+var minServerVersion = version.MustParseGeneric("v1.22.0")
+
+// PreflightStatus is the outcome of a single preflight check.
+type PreflightStatus string
+
+const (
+	PreflightPass PreflightStatus = "PASS"
+	PreflightWarn PreflightStatus = "WARN"
+	PreflightFail PreflightStatus = "FAIL"
+)
+
+// PreflightCheck is one row of the preflight report.
+type PreflightCheck struct {
+	Name    string          `json:"name"`
+	Status  PreflightStatus `json:"status"`
+	Message string          `json:"message"`
+}
+
+// PreflightOptions selects what the checks cover: the migration Job, the
+// Deployment and the Service are each checked only when they will be applied.
+type PreflightOptions struct {
+	Namespace       string
+	CreateNamespace bool
+	Migrate         bool
+	Deploy          bool
+	Service         bool
+	SkipSecrets     bool
+}
+
+// PreflightFailed reports whether any check failed.
+func PreflightFailed(checks []PreflightCheck) bool {
+	for _, check := range checks {
+		if check.Status == PreflightFail {
+			return true
+		}
+	}
+	return false
+}
+
+// permission is a verb on a resource that kubeinit needs.
+type permission struct {
+	group       string
+	resource    string
+	subresource string
+	verbs       []string
+	clusterWide bool
+}
+
+func (p permission) String() string {
+	name := p.resource
+	if p.subresource != "" {
+		name += "/" + p.subresource
+	}
+	if p.group != "" {
+		name += "." + p.group
+	}
+	return name
+}
+
+// checksSecrets reports whether the pods started by the selected phases need the
+// spec's secrets to be checked.
+func checksSecrets(spec *appspec.AppSpec, opts PreflightOptions) bool {
+	return !opts.SkipSecrets && (opts.Migrate || opts.Deploy) && len(spec.SecretNames()) > 0
+}
+
+// requiredPermissions lists the API access used by the selected phases.
+func requiredPermissions(spec *appspec.AppSpec, opts PreflightOptions) []permission {
+	var perms []permission
+	if opts.CreateNamespace {
+		perms = append(perms, permission{resource: "namespaces", verbs: []string{"get", "create", "patch"}, clusterWide: true})
+	}
+	if checksSecrets(spec, opts) {
+		perms = append(perms, permission{resource: "secrets", verbs: []string{"get"}})
+	}
+	if opts.Migrate && spec.Migration != nil {
+		perms = append(perms,
+			permission{group: "batch", resource: "jobs", verbs: []string{"get", "list", "watch", "create", "patch"}},
+			permission{resource: "pods", subresource: "log", verbs: []string{"get"}},
+		)
+	}
+	if opts.Deploy {
+		perms = append(perms,
+			permission{group: "apps", resource: "deployments", verbs: []string{"get", "watch", "patch"}},
+			permission{group: "apps", resource: "replicasets", verbs: []string{"list"}},
+		)
+	}
+	if opts.Migrate && spec.Migration != nil || opts.Deploy {
+		perms = append(perms, permission{resource: "pods", verbs: []string{"list"}})
+	}
+	if opts.Service && spec.Service != nil {
+		perms = append(perms, permission{resource: "services", verbs: []string{"get", "create", "update"}})
+	}
+	return perms
+}
+
+// Preflight runs read-only checks against the cluster and returns one row per
+// check. Nothing is mutated, so it is safe to run before every deploy.
+func (k *KubeClient) Preflight(spec *appspec.AppSpec, opts PreflightOptions) []PreflightCheck {
+	ctx, cancel := k.operationContext()
+	defer cancel()
+
+	checks := []PreflightCheck{k.checkServerVersion()}
+	if checks[0].Status == PreflightFail {
+		return checks
+	}
+
+	namespaceCheck, namespaceExists := k.checkNamespace(ctx, opts)
+	checks = append(checks, namespaceCheck)
+	checks = append(checks, k.checkPermissions(ctx, requiredPermissions(spec, opts), opts.Namespace)...)
+	if !namespaceExists {
+		return checks
+	}
+	if checksSecrets(spec, opts) {
+		checks = append(checks, k.checkSecrets(ctx, spec, opts.Namespace)...)
+	}
+
+	pods := preflightPods(spec, opts, k.newDeploymentPods(ctx, spec, opts))
+	checks = append(checks, k.checkLimitRanges(ctx, pods, opts.Namespace)...)
+	checks = append(checks, k.checkResourceQuotas(ctx, pods, opts.Namespace)...)
+	return checks
+}
+
+func (k *KubeClient) checkServerVersion() PreflightCheck {
+	check := PreflightCheck{Name: "cluster"}
+	info, err := k.Client.Discovery().ServerVersion()
+	if err != nil {
+		check.Status, check.Message = PreflightFail, fmt.Sprintf("unreachable: %s", err.Error())
+		return check
+	}
+	check.Message = fmt.Sprintf("%s reachable, server %s", k.Config.Host, info.GitVersion)
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	switch {
+	case err != nil:
+		check.Status, check.Message = PreflightWarn, check.Message+", unable to parse version"
+	case serverVersion.LessThan(minServerVersion):
+		check.Status, check.Message = PreflightFail, check.Message+fmt.Sprintf(", %s or newer is required", minServerVersion)
+	default:
+		check.Status = PreflightPass
+	}
+	return check
+}
+
+func (k *KubeClient) checkNamespace(ctx context.Context, opts PreflightOptions) (PreflightCheck, bool) {
+	check := PreflightCheck{Name: "namespace " + opts.Namespace}
+	_, err := k.Client.CoreV1().Namespaces().Get(ctx, opts.Namespace, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		check.Status, check.Message = PreflightPass, "exists"
+		return check, true
+	case apierrors.IsNotFound(err) && opts.CreateNamespace:
+		check.Status, check.Message = PreflightWarn, "does not exist, will be created"
+	case apierrors.IsNotFound(err):
+		check.Status, check.Message = PreflightFail, "does not exist, use -create-namespace to create it"
+	case apierrors.IsForbidden(err):
+		// Namespaced users often cannot read their own Namespace object.
+		check.Status, check.Message = PreflightWarn, "unable to verify: "+err.Error()
+		return check, true
+	default:
+		check.Status, check.Message = PreflightFail, err.Error()
+	}
+	return check, false
+}
+
+// checkPermissions asks the apiserver, with SelfSubjectAccessReviews, whether the
+// current user may perform every verb kubeinit will use.
+func (k *KubeClient) checkPermissions(ctx context.Context, perms []permission, namespace string) []PreflightCheck {
+	var checks []PreflightCheck
+	for _, perm := range perms {
+		check := PreflightCheck{Name: "rbac " + perm.String(), Status: PreflightPass, Message: strings.Join(perm.verbs, ", ")}
+		var denied []string
+		for _, verb := range perm.verbs {
+			review := &authorizationv1.SelfSubjectAccessReview{
+				Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+					ResourceAttributes: &authorizationv1.ResourceAttributes{
+						Verb:        verb,
+						Group:       perm.group,
+						Resource:    perm.resource,
+						Subresource: perm.subresource,
+					},
+				},
+			}
+			if !perm.clusterWide {
+				review.Spec.ResourceAttributes.Namespace = namespace
+			}
+			result, err := k.Client.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+			if err != nil {
+				check.Status, check.Message = PreflightWarn, "unable to check: "+err.Error()
+				denied = nil
+				break
+			}
+			if !result.Status.Allowed {
+				denied = append(denied, verb)
+			}
+		}
+		if len(denied) > 0 {
+			check.Status, check.Message = PreflightFail, "denied: "+strings.Join(denied, ", ")
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// checkSecrets confirms every Secret referenced by the spec exists, and that
+// image pull secrets hold registry credentials.
+func (k *KubeClient) checkSecrets(ctx context.Context, spec *appspec.AppSpec, namespace string) []PreflightCheck {
+	pullSecrets := make(map[string]bool)
+	for _, name := range spec.ImagePullSecrets {
+		pullSecrets[name] = true
+	}
+
+	var checks []PreflightCheck
+	for _, name := range spec.SecretNames() {
+		check := PreflightCheck{Name: "secret " + name, Status: PreflightPass, Message: "exists"}
+		if pullSecrets[name] {
+			check.Name = "image pull secret " + name
+		}
+		secret, err := k.Client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			check.Status, check.Message = PreflightFail, "not found in namespace "+namespace
+		case err != nil:
+			check.Status, check.Message = PreflightFail, err.Error()
+		case pullSecrets[name] && secret.Type != corev1.SecretTypeDockerConfigJson && secret.Type != corev1.SecretTypeDockercfg:
+			check.Status, check.Message = PreflightWarn, fmt.Sprintf("has type %s, not %s", secret.Type, corev1.SecretTypeDockerConfigJson)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// preflightPod is a pod kubeinit will create count copies of at once, used to
+// validate resource requests.
+type preflightPod struct {
+	name  string
+	spec  corev1.PodSpec
+	count int64
+}
+
+// newDeploymentPods returns how many new pods the rollout starts at once: every
+// replica when the Deployment is new, otherwise one surge pod.
+func (k *KubeClient) newDeploymentPods(ctx context.Context, spec *appspec.AppSpec, opts PreflightOptions) int64 {
+	if !opts.Deploy || spec.Replicas == nil {
+		return 1
+	}
+	_, err := k.Client.AppsV1().Deployments(opts.Namespace).Get(ctx, spec.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return int64(*spec.Replicas)
+	}
+	return 1
+}
+
+func preflightPods(spec *appspec.AppSpec, opts PreflightOptions, deploymentPods int64) []preflightPod {
+	var pods []preflightPod
+	if opts.Migrate {
+		if job := spec.MigrationJob("migration", opts.Namespace, nil, nil); job != nil {
+			pods = append(pods, preflightPod{name: "migration job", spec: job.Spec.Template.Spec, count: 1})
+		}
+	}
+	if opts.Deploy {
+		pods = append(pods, preflightPod{
+			name:  "deployment " + spec.Name,
+			spec:  spec.Deployment(opts.Namespace).Spec.Template.Spec,
+			count: deploymentPods,
+		})
+	}
+	return pods
+}
+
+// checkLimitRanges validates container requests and limits against the
+// namespace's LimitRange minimums, maximums and ratios.
+func (k *KubeClient) checkLimitRanges(ctx context.Context, pods []preflightPod, namespace string) []PreflightCheck {
+	if len(pods) == 0 {
+		return nil
+	}
+	limitRanges, err := k.Client.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return []PreflightCheck{{Name: "limit ranges", Status: PreflightWarn, Message: "unable to list: " + err.Error()}}
+	}
+	if len(limitRanges.Items) == 0 {
+		return nil
+	}
+
+	var checks []PreflightCheck
+	for _, pod := range pods {
+		var problems []string
+		for _, lr := range limitRanges.Items {
+			for _, item := range lr.Spec.Limits {
+				if item.Type != corev1.LimitTypeContainer {
+					continue
+				}
+				for _, c := range pod.spec.Containers {
+					problems = append(problems, containerLimitProblems(lr.Name, item, c)...)
+				}
+			}
+		}
+		check := PreflightCheck{Name: "limit range " + pod.name, Status: PreflightPass, Message: "within limits"}
+		if len(problems) > 0 {
+			check.Status, check.Message = PreflightFail, strings.Join(problems, "; ")
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func containerLimitProblems(limitRange string, item corev1.LimitRangeItem, c corev1.Container) []string {
+	var problems []string
+	for name, min := range item.Min {
+		if request, ok := c.Resources.Requests[name]; ok && request.Cmp(min) < 0 {
+			problems = append(problems, fmt.Sprintf("%s %s request %s is below %s min %s", c.Name, name, request.String(), limitRange, min.String()))
+		}
+	}
+	for name, max := range item.Max {
+		if limit, ok := c.Resources.Limits[name]; ok && limit.Cmp(max) > 0 {
+			problems = append(problems, fmt.Sprintf("%s %s limit %s is above %s max %s", c.Name, name, limit.String(), limitRange, max.String()))
+		}
+	}
+	for name, ratio := range item.MaxLimitRequestRatio {
+		limit, hasLimit := c.Resources.Limits[name]
+		request, hasRequest := c.Resources.Requests[name]
+		if !hasLimit || !hasRequest || request.IsZero() {
+			continue
+		}
+		if float64(limit.MilliValue())/float64(request.MilliValue()) > ratio.AsApproximateFloat64() {
+			problems = append(problems, fmt.Sprintf("%s %s limit/request ratio exceeds %s max %s", c.Name, name, limitRange, ratio.String()))
+		}
+	}
+	return problems
+}
+
+// quotaResources maps quota names to the container resource they count.
+var quotaResources = map[corev1.ResourceName]struct {
+	resource corev1.ResourceName
+	limits   bool
+}{
+	corev1.ResourceCPU:            {corev1.ResourceCPU, false},
+	corev1.ResourceMemory:         {corev1.ResourceMemory, false},
+	corev1.ResourceRequestsCPU:    {corev1.ResourceCPU, false},
+	corev1.ResourceRequestsMemory: {corev1.ResourceMemory, false},
+	corev1.ResourceLimitsCPU:      {corev1.ResourceCPU, true},
+	corev1.ResourceLimitsMemory:   {corev1.ResourceMemory, true},
+}
+
+// podUsage sums the requests or limits of a pod's containers.
+func podUsage(pod corev1.PodSpec, name corev1.ResourceName, limits bool) resource.Quantity {
+	total := resource.Quantity{}
+	for _, c := range pod.Containers {
+		list := c.Resources.Requests
+		if limits {
+			list = c.Resources.Limits
+		}
+		if q, ok := list[name]; ok {
+			total.Add(q)
+		}
+	}
+	return total
+}
+
+// checkResourceQuotas checks the namespace quotas have room for the pods kubeinit
+// will start. The migration Job finishes before the rollout, so only the larger of
+// the two has to fit.
+func (k *KubeClient) checkResourceQuotas(ctx context.Context, pods []preflightPod, namespace string) []PreflightCheck {
+	if len(pods) == 0 {
+		return nil
+	}
+	quotas, err := k.Client.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return []PreflightCheck{{Name: "resource quotas", Status: PreflightWarn, Message: "unable to list: " + err.Error()}}
+	}
+	if len(quotas.Items) == 0 {
+		return nil
+	}
+
+	need := func(name corev1.ResourceName, limits bool) resource.Quantity {
+		max := resource.Quantity{}
+		for _, pod := range pods {
+			usage := *resource.NewQuantity(pod.count, resource.DecimalSI)
+			if name != corev1.ResourcePods {
+				usage = podUsage(pod.spec, name, limits)
+				usage.Mul(pod.count)
+			}
+			if usage.Cmp(max) > 0 {
+				max = usage
+			}
+		}
+		return max
+	}
+
+	var checks []PreflightCheck
+	for _, quota := range quotas.Items {
+		check := PreflightCheck{Name: "quota " + quota.Name, Status: PreflightPass, Message: "enough room"}
+		var problems []string
+		for name, hard := range quota.Status.Hard {
+			var needed resource.Quantity
+			if name == corev1.ResourcePods {
+				needed = need(corev1.ResourcePods, false)
+			} else if counted, ok := quotaResources[name]; ok {
+				needed = need(counted.resource, counted.limits)
+			} else {
+				continue
+			}
+			available := hard.DeepCopy()
+			available.Sub(quota.Status.Used[name])
+			if needed.Cmp(available) > 0 {
+				problems = append(problems, fmt.Sprintf("%s needs %s, %s available", name, needed.String(), available.String()))
+			}
+		}
+		if len(problems) > 0 {
+			check.Status, check.Message = PreflightFail, strings.Join(problems, "; ")
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/babbage88/infra-kubeinit/internal/appspec"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// permits reports whether perms allow the request made by action.
+func permits(perms []permission, action k8stesting.Action) bool {
+	resource := action.GetResource()
+	for _, p := range perms {
+		if p.group == resource.Group && p.resource == resource.Resource &&
+			p.subresource == action.GetSubresource() && slices.Contains(p.verbs, action.GetVerb()) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestRequiredPermissionsCoverClientCalls runs every client call of the migrate
+// and deploy paths against a fake clientset and checks that preflight asks for
+// each of them, so a deploy cannot pass preflight and then fail half way.
+func TestRequiredPermissionsCoverClientCalls(t *testing.T) {
+	const namespace = "staging"
+	spec := appspec.Default("go-infra", "ghcr.io/babbage88/go-infra:v1.2.2", "ghcr.io/babbage88/init-infradb:v1.2.2", 8993, 1)
+	secrets := make([]runtime.Object, 0, len(spec.SecretNames()))
+	for _, name := range spec.SecretNames() {
+		secrets = append(secrets, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}})
+	}
+	k, clientset := newFakeKubeClient(t, secrets...)
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-pod", Namespace: namespace, Labels: map[string]string{"job-name": job.Name}},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "migrate"}}},
+		}
+		if err := clientset.Tracker().Add(pod); err != nil {
+			return true, nil, err
+		}
+		return completeCreatedJobs(action)
+	})
+	// Report every Deployment as rolled out, and every Service as having an address.
+	clientset.PrependReactor("get", "deployments", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), action.(k8stesting.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		deployment := obj.(*appsv1.Deployment)
+		deployment.Status = appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+		return true, deployment, nil
+	})
+	clientset.PrependReactor("get", "services", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), action.(k8stesting.GetAction).GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		service := obj.(*corev1.Service)
+		service.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "192.0.2.10"}}
+		return true, service, nil
+	})
+
+	if err := k.EnsureNamespace(namespace, map[string]string{"team": "infra"}); err != nil {
+		t.Fatalf("EnsureNamespace() error = %v", err)
+	}
+	if err := k.EnsureNamespace(namespace, map[string]string{"team": "platform"}); err != nil {
+		t.Fatalf("EnsureNamespace() relabel error = %v", err)
+	}
+	if err := k.VerifySecrets(namespace, spec.SecretNames()); err != nil {
+		t.Fatalf("VerifySecrets() error = %v", err)
+	}
+	if _, err := k.PrepDeployment(MigrationOptions{
+		Spec:       spec,
+		Namespace:  namespace,
+		Image:      spec.Migration.Container.Image,
+		Timeout:    5 * time.Second,
+		FollowLogs: true,
+	}); err != nil {
+		t.Fatalf("PrepDeployment() error = %v", err)
+	}
+	jobs, err := k.GetBatchJobByLabel(namespace, MigrationHistorySelector(spec.Name, ""))
+	if err != nil || len(jobs.Items) == 0 {
+		t.Fatalf("GetBatchJobByLabel() = %v, %v, want the migration job", jobs, err)
+	}
+	if _, err := k.TailJobLogs(namespace, jobs.Items[0].Name, 10); err != nil {
+		t.Fatalf("TailJobLogs() error = %v", err)
+	}
+
+	if _, err := k.GetDeploymentTemplate(namespace, spec.Name); err != nil {
+		t.Fatalf("GetDeploymentTemplate() error = %v", err)
+	}
+	ns := namespace
+	if err := k.CreateOrUpdateDeployment(&ns, spec, true, ApplyOptions{}); err != nil {
+		t.Fatalf("CreateOrUpdateDeployment() error = %v", err)
+	}
+	if err := k.WaitForRollout(namespace, spec.Name, 5*time.Second); err != nil {
+		t.Fatalf("WaitForRollout() error = %v", err)
+	}
+	deployment, err := k.GetDeployment(namespace, spec.Name)
+	if err != nil {
+		t.Fatalf("GetDeployment() error = %v", err)
+	}
+	if err := k.checkRolloutPods(k.Ctx, deployment); err != nil {
+		t.Fatalf("checkRolloutPods() error = %v", err)
+	}
+	// Without a previous template the rollback looks up the previous ReplicaSet,
+	// which the fake cluster does not have.
+	if err := k.RollbackDeployment(namespace, spec, nil, "test", 5*time.Second); err == nil {
+		t.Fatal("RollbackDeployment() without a previous revision succeeded")
+	}
+	if err := k.RollbackDeployment(namespace, spec, &deployment.Spec.Template, "test", 5*time.Second); err != nil {
+		t.Fatalf("RollbackDeployment() error = %v", err)
+	}
+
+	service := spec.RenderService(namespace)
+	for range 2 {
+		if err := k.CreateOrUpdateService(service); err != nil {
+			t.Fatalf("CreateOrUpdateService() error = %v", err)
+		}
+	}
+	if _, err := k.WaitForLoadBalancerIngress(namespace, service.Name, 5*time.Second); err != nil {
+		t.Fatalf("WaitForLoadBalancerIngress() error = %v", err)
+	}
+
+	perms := requiredPermissions(spec, PreflightOptions{
+		Namespace:       namespace,
+		CreateNamespace: true,
+		Migrate:         true,
+		Deploy:          true,
+		Service:         true,
+	})
+	for _, action := range clientset.Actions() {
+		if !permits(perms, action) {
+			t.Errorf("requiredPermissions() does not cover %s %s/%s in group %q",
+				action.GetVerb(), action.GetResource().Resource, action.GetSubresource(), action.GetResource().Group)
+		}
+	}
+}